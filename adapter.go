@@ -0,0 +1,169 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"sync"
+)
+
+// Message is a broadcast message.
+type Message struct {
+	Data   []byte
+	Binary bool
+	Rooms  []string // target rooms, all sessions if empty
+	Except []string // excluded session ids
+}
+
+// Adapter manages the room memberships of the sessions and delivers
+// broadcast messages, possibly to the sessions of other nodes.
+// Implementations must be safe for concurrent use.
+type Adapter interface {
+	// Init binds the adapter to the local sessions. It is called once
+	// by NewEngineIO.
+	Init(sessions SessionStore)
+
+	// Join adds session sid to room.
+	Join(sid, room string)
+
+	// Leave removes session sid from room.
+	Leave(sid, room string)
+
+	// LeaveAll removes session sid from all rooms.
+	LeaveAll(sid string)
+
+	// Broadcast delivers m to the targeted sessions. Failed writes to
+	// local sessions are returned as BroadcastError.
+	Broadcast(m *Message) error
+}
+
+// memoryAdapter is an in-process Adapter, broadcasts reach the local
+// sessions only.
+type memoryAdapter struct {
+	*rooms
+	sessions SessionStore
+}
+
+// NewMemoryAdapter returns a new in-process Adapter.
+func NewMemoryAdapter() Adapter {
+	return &memoryAdapter{rooms: newRooms()}
+}
+
+func (a *memoryAdapter) Init(sessions SessionStore) {
+	a.sessions = sessions
+}
+
+func (a *memoryAdapter) Broadcast(m *Message) error {
+	errs := make(BroadcastError)
+	except := make(map[string]bool, len(m.Except))
+	for _, sid := range m.Except {
+		except[sid] = true
+	}
+
+	write := func(sid string, conn Connection) bool {
+		if except[sid] {
+			return true
+		}
+
+		var err error
+		if m.Binary {
+			_, err = conn.WriteBinary(m.Data)
+		} else {
+			_, err = conn.Write(m.Data)
+		}
+		if err != nil {
+			errs[sid] = err
+		}
+		return true
+	}
+
+	if len(m.Rooms) == 0 {
+		a.sessions.Range(write)
+	} else {
+		for sid := range a.members(m.Rooms) {
+			if conn, found := a.sessions.Get(sid); found {
+				write(sid, conn)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// rooms holds the room memberships of the sessions.
+type rooms struct {
+	mu       sync.RWMutex
+	rooms    map[string]map[string]bool // session ids by room
+	sessions map[string]map[string]bool // rooms by session id
+}
+
+func newRooms() *rooms {
+	return &rooms{
+		rooms:    make(map[string]map[string]bool),
+		sessions: make(map[string]map[string]bool),
+	}
+}
+
+func (r *rooms) Join(sid, room string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rooms[room] == nil {
+		r.rooms[room] = make(map[string]bool)
+	}
+	r.rooms[room][sid] = true
+
+	if r.sessions[sid] == nil {
+		r.sessions[sid] = make(map[string]bool)
+	}
+	r.sessions[sid][room] = true
+}
+
+func (r *rooms) Leave(sid, room string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.remove(sid, room)
+}
+
+// LeaveAll removes session sid from all rooms.
+func (r *rooms) LeaveAll(sid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for room := range r.sessions[sid] {
+		r.remove(sid, room)
+	}
+}
+
+// remove removes session sid from room, r.mu must be held.
+func (r *rooms) remove(sid, room string) {
+	delete(r.rooms[room], sid)
+	if len(r.rooms[room]) == 0 {
+		delete(r.rooms, room)
+	}
+
+	delete(r.sessions[sid], room)
+	if len(r.sessions[sid]) == 0 {
+		delete(r.sessions, sid)
+	}
+}
+
+// members returns the session ids of the members of rooms.
+func (r *rooms) members(rooms []string) map[string]bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sids := make(map[string]bool)
+	for _, room := range rooms {
+		for sid := range r.rooms[room] {
+			sids[sid] = true
+		}
+	}
+	return sids
+}
@@ -0,0 +1,88 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BroadcastError holds the write errors of a broadcast by session id.
+type BroadcastError map[string]error
+
+// Error reports the number of failed writes along with the error of the
+// lowest session id, so the message is stable.
+func (e BroadcastError) Error() string {
+	if len(e) == 0 {
+		return "broadcast: no errors"
+	}
+
+	sids := make([]string, 0, len(e))
+	for sid := range e {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	return fmt.Sprintf("broadcast: %d write(s) failed, session %s: %v", len(e), sids[0], e[sids[0]])
+}
+
+// Broadcaster writes messages to a set of sessions. A Broadcaster is
+// obtained by EngineIO.To and EngineIO.Except; it is immutable, To and
+// Except return a new Broadcaster.
+type Broadcaster struct {
+	e      *EngineIO
+	rooms  []string        // target rooms, all sessions if empty
+	except map[string]bool // excluded session ids
+}
+
+// To returns a Broadcaster additionally targeting the members of room.
+func (b *Broadcaster) To(room string) *Broadcaster {
+	nb := &Broadcaster{
+		e:      b.e,
+		rooms:  append(append([]string{}, b.rooms...), room),
+		except: b.except,
+	}
+	return nb
+}
+
+// Except returns a Broadcaster excluding conns.
+func (b *Broadcaster) Except(conns ...Connection) *Broadcaster {
+	nb := &Broadcaster{
+		e:      b.e,
+		rooms:  b.rooms,
+		except: make(map[string]bool, len(b.except)+len(conns)),
+	}
+	for sid := range b.except {
+		nb.except[sid] = true
+	}
+	for _, conn := range conns {
+		nb.except[conn.ID()] = true
+	}
+	return nb
+}
+
+// Write writes data as message to each targeted session. The returned
+// error is a BroadcastError if any write fails.
+func (b *Broadcaster) Write(data []byte) error {
+	return b.e.adapter.Broadcast(b.message(data, false))
+}
+
+// WriteBinary writes data as binary message to each targeted session.
+// The returned error is a BroadcastError if any write fails.
+func (b *Broadcaster) WriteBinary(data []byte) error {
+	return b.e.adapter.Broadcast(b.message(data, true))
+}
+
+func (b *Broadcaster) message(data []byte, binary bool) *Message {
+	m := &Message{
+		Data:   data,
+		Binary: binary,
+		Rooms:  b.rooms,
+	}
+	for sid := range b.except {
+		m.Except = append(m.Except, sid)
+	}
+	return m
+}
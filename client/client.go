@@ -0,0 +1,313 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+// Package client implements an engine.io client speaking protocol v4.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/massiveart/engineio/internal/inbox"
+	"github.com/massiveart/engineio/parser"
+)
+
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrClosed       = errors.New("client closed")
+)
+
+// Client is a connection to an engine.io server. If the session is lost
+// and reconnecting is enabled, a new session is established transparently;
+// ID returns the id of the current session.
+type Client struct {
+	url    *url.URL
+	config *Config
+
+	inbox *inbox.Inbox
+	done  chan struct{} // closed if the client is closed or gives up
+	once  sync.Once
+
+	mu      sync.Mutex // protects session and closed
+	session *session
+	closed  bool
+}
+
+// Dial connects to the engine.io server at rawurl, e.g.
+// "http://localhost:9090/engine.io/". If config is nil, the
+// DefaultConfig is used.
+func Dial(rawurl string, config *Config) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = DefaultConfig
+	}
+
+	c := &Client{
+		url:    u,
+		config: config,
+		inbox:  inbox.New(config.QueueLength),
+		done:   make(chan struct{}),
+	}
+	if err = c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ID returns the id of the current session, or an empty string if not
+// connected.
+func (c *Client) ID() string {
+	if s := c.current(); s != nil {
+		return s.sid
+	}
+	return ""
+}
+
+func (c *Client) Write(data []byte) (int, error) {
+	return c.write(parser.Packet{Type: parser.Message, Data: data})
+}
+
+// WriteBinary writes data as a binary message.
+func (c *Client) WriteBinary(data []byte) (int, error) {
+	return c.write(parser.Packet{Type: parser.Message, Data: data, Binary: true})
+}
+
+func (c *Client) write(p parser.Packet) (int, error) {
+	s := c.current()
+	if s == nil {
+		return 0, ErrNotConnected
+	}
+
+	if err := s.send(p); err != nil {
+		return 0, err
+	}
+	return len(p.Data), nil
+}
+
+// ReadMessage returns the next message. It blocks until a message arrives
+// or the client is closed, in which case io.EOF is returned once all
+// buffered messages are read.
+func (c *Client) ReadMessage() ([]byte, error) {
+	return c.inbox.ReadMessage()
+}
+
+// Read reads the data of the inbound messages, message boundaries are
+// not preserved.
+func (c *Client) Read(p []byte) (int, error) {
+	return c.inbox.Read(p)
+}
+
+// Close closes the current session and stops reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	s := c.session
+	c.session = nil
+	c.mu.Unlock()
+
+	var err error
+	if s != nil {
+		err = s.send(parser.Packet{Type: parser.Close})
+		s.close()
+	}
+	c.shutdown()
+	return err
+}
+
+func (c *Client) current() *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+func (c *Client) shutdown() {
+	c.once.Do(func() {
+		close(c.done)
+		c.inbox.Close()
+	})
+}
+
+// connect establishes a new session.
+func (c *Client) connect() error {
+	s, packets, err := c.handshake()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		s.close()
+		return ErrClosed
+	}
+	c.session = s
+	c.mu.Unlock()
+
+	s.start(packets)
+	return nil
+}
+
+// handshake opens a session with the first configured transport. It
+// returns the session along with the packets received after the open
+// packet.
+func (c *Client) handshake() (*session, []parser.Packet, error) {
+	if len(c.config.Transports) == 0 {
+		return nil, nil, errors.New("handshake: no transports")
+	}
+
+	u := *c.url
+	query := u.Query()
+	query.Set("EIO", fmt.Sprint(parser.Protocol4))
+	u.RawQuery = query.Encode()
+
+	var (
+		t       transport
+		packets []parser.Packet
+		err     error
+	)
+	switch name := c.config.Transports[0]; name {
+	case "polling":
+		pt := newPollingTransport(c.httpClient(), &u, c.config.Header)
+		if packets, err = pt.poll(); err != nil {
+			return nil, nil, err
+		}
+		t = pt
+
+	case "websocket":
+		wt, err := dialWebsocket(&u, c.config.Header)
+		if err != nil {
+			return nil, nil, err
+		}
+		p, err := wt.receive()
+		if err != nil {
+			wt.close()
+			return nil, nil, err
+		}
+		packets = []parser.Packet{p}
+		t = wt
+
+	default:
+		return nil, nil, fmt.Errorf("handshake: unknown transport %q", name)
+	}
+
+	if len(packets) == 0 || packets[0].Type != parser.Open {
+		t.close()
+		return nil, nil, errors.New("handshake: expected open packet")
+	}
+
+	var open struct {
+		Sid          string   `json:"sid"`
+		Upgrades     []string `json:"upgrades"`
+		PingInterval int64    `json:"pingInterval"`
+		PingTimeout  int64    `json:"pingTimeout"`
+	}
+	if err = json.Unmarshal(packets[0].Data, &open); err != nil {
+		t.close()
+		return nil, nil, err
+	}
+
+	query.Set("sid", open.Sid)
+	u.RawQuery = query.Encode()
+	if pt, ok := t.(*pollingTransport); ok {
+		pt.url = &u
+	}
+
+	s := &session{
+		client:       c,
+		sid:          open.Sid,
+		url:          &u,
+		upgrade:      c.upgradable(open.Upgrades),
+		pingInterval: time.Duration(open.PingInterval) * time.Millisecond,
+		pingTimeout:  time.Duration(open.PingTimeout) * time.Millisecond,
+		transport:    t,
+		done:         make(chan struct{}),
+		polled:       make(chan struct{}),
+	}
+	// the session might be closed before it is started
+	s.heartbeat = time.AfterFunc(s.pingInterval+s.pingTimeout, s.close)
+	return s, packets[1:], nil
+}
+
+// upgradable reports if the session can be upgraded to websocket.
+func (c *Client) upgradable(upgrades []string) bool {
+	if c.config.Transports[0] != "polling" {
+		return false
+	}
+
+	allowed := false
+	for _, name := range c.config.Transports[1:] {
+		if name == "websocket" {
+			allowed = true
+		}
+	}
+	for _, name := range upgrades {
+		if name == "websocket" {
+			return allowed
+		}
+	}
+	return false
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.config.HTTPClient != nil {
+		return c.config.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// lost is invoked if session s ends. Unless the client is closed, a new
+// session is established if reconnecting is enabled.
+func (c *Client) lost(s *session) {
+	c.mu.Lock()
+	if c.session != s {
+		c.mu.Unlock()
+		return
+	}
+	c.session = nil
+	c.mu.Unlock()
+
+	if !c.config.Reconnect {
+		c.shutdown()
+		return
+	}
+	go c.reconnect()
+}
+
+func (c *Client) reconnect() {
+	delay := time.Duration(c.config.ReconnectDelay) * time.Millisecond
+	max := c.config.MaxReconnectAttempts
+
+	for attempt := 1; max == 0 || attempt <= max; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		if err := c.connect(); err == nil || err == ErrClosed {
+			return
+		}
+	}
+	c.shutdown()
+}
+
+// deliver buffers an inbound message. If the buffer is full, the message
+// is dropped.
+func (c *Client) deliver(data []byte) {
+	c.inbox.Push(data)
+}
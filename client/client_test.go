@@ -0,0 +1,136 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package client
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/massiveart/engineio"
+)
+
+func newServer() (*engineio.EngineIO, *httptest.Server) {
+	e := engineio.NewEngineIO(&engineio.Config{
+		QueueLength:  10,
+		PingInterval: 200,
+		PingTimeout:  1000,
+		Upgrades:     []string{"websocket"},
+	})
+	e.MessageFunc(func(conn engineio.Connection, data []byte) error {
+		_, err := conn.Write(data)
+		return err
+	})
+	return e, httptest.NewServer(e)
+}
+
+func echo(t *testing.T, c *Client, msg string) {
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != msg {
+		t.Fatalf("read: expect %q, got %q", msg, data)
+	}
+}
+
+func TestPolling(t *testing.T) {
+	_, server := newServer()
+	defer server.Close()
+
+	c, err := Dial(server.URL+engineio.DefaultEngineioPath, &Config{
+		Transports: []string{"polling"},
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	if c.ID() == "" {
+		t.Fatalf("dial: expect session id")
+	}
+	echo(t, c, "polling")
+}
+
+func TestUpgrade(t *testing.T) {
+	_, server := newServer()
+	defer server.Close()
+
+	c, err := Dial(server.URL+engineio.DefaultEngineioPath, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; ; i++ {
+		s := c.current()
+		s.mu.Lock()
+		_, upgraded := s.transport.(*websocketTransport)
+		s.mu.Unlock()
+		if upgraded {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("upgrade: expect websocket transport")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	echo(t, c, "websocket")
+}
+
+func TestReconnect(t *testing.T) {
+	e, server := newServer()
+	defer server.Close()
+
+	var conns = make(chan engineio.Connection, 2)
+	e.ConnectionFunc(func(conn engineio.Connection) {
+		conns <- conn
+	})
+
+	c, err := Dial(server.URL+engineio.DefaultEngineioPath, &Config{
+		Transports:     []string{"websocket"},
+		Reconnect:      true,
+		ReconnectDelay: 10,
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	conn := <-conns
+	if conn.ID() != c.ID() {
+		t.Fatalf("dial: expect session id %q, got %q", conn.ID(), c.ID())
+	}
+	conn.Close()
+
+	conn = <-conns
+	for i := 0; c.ID() != conn.ID(); i++ {
+		if i == 50 {
+			t.Fatalf("reconnect: expect session id %q, got %q", conn.ID(), c.ID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	echo(t, c, "reconnected")
+}
+
+func TestCloseReconnect(t *testing.T) {
+	_, server := newServer()
+	defer server.Close()
+
+	c, err := Dial(server.URL+engineio.DefaultEngineioPath, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c.Close()
+
+	// a reconnect racing Close closes the new session before it starts
+	if err = c.connect(); err != ErrClosed {
+		t.Fatalf("connect: expect %v, got %v", ErrClosed, err)
+	}
+}
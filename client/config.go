@@ -0,0 +1,44 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package client
+
+import "net/http"
+
+type Config struct {
+	// Transports to use, in order of preference. The connection is
+	// established with the first transport, "websocket" following
+	// "polling" is probed and upgraded to.
+	Transports []string
+
+	// Header is sent along with each request.
+	Header http.Header
+
+	// HTTPClient is used for polling requests. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Maximum amount of inbound messages to buffer for ReadMessage,
+	// further messages are dropped until they are read.
+	QueueLength int
+
+	// Reconnect indicates if a new session is established after the
+	// session is lost.
+	Reconnect bool
+
+	// Delay between reconnect attempts in milliseconds.
+	ReconnectDelay int64
+
+	// Maximum amount of consecutive reconnect attempts, 0 means
+	// unlimited.
+	MaxReconnectAttempts int
+}
+
+var DefaultConfig = &Config{
+	Transports:     []string{"polling", "websocket"},
+	QueueLength:    10,
+	Reconnect:      true,
+	ReconnectDelay: 1000,
+}
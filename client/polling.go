@@ -0,0 +1,95 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/massiveart/engineio/parser"
+)
+
+type pollingTransport struct {
+	client *http.Client
+	url    *url.URL
+	header http.Header
+
+	ctx    context.Context // canceled on close
+	cancel context.CancelFunc
+}
+
+func newPollingTransport(client *http.Client, u *url.URL, header http.Header) *pollingTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &pollingTransport{
+		client: client,
+		url:    u,
+		header: header,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// poll returns the packets of a single long polling request.
+func (t *pollingTransport) poll() ([]parser.Packet, error) {
+	data, err := t.do("GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	return parser.DecodePayload(data, parser.Protocol4)
+}
+
+func (t *pollingTransport) send(packets ...parser.Packet) error {
+	data, _ := parser.EncodePayload(packets, parser.Protocol4, true)
+	_, err := t.do("POST", data)
+	return err
+}
+
+// close cancels pending requests.
+func (t *pollingTransport) close() error {
+	t.cancel()
+	return nil
+}
+
+func (t *pollingTransport) do(method string, body []byte) ([]byte, error) {
+	u := *t.url
+	query := u.Query()
+	query.Set("transport", "polling")
+	query.Set("t", strconv.FormatInt(time.Now().UnixNano(), 36))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(t.ctx)
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("polling: %s: %s", res.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
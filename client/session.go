@@ -0,0 +1,209 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package client
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/massiveart/engineio/parser"
+)
+
+// transport sends packets to the server.
+type transport interface {
+	send(packets ...parser.Packet) error
+	close() error
+}
+
+// session is a single engine.io session of a client.
+type session struct {
+	client *Client
+
+	sid          string
+	url          *url.URL // endpoint including protocol and session id
+	upgrade      bool     // indicates if websocket is probed
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	mu        sync.Mutex // protects transport and upgrading
+	transport transport
+	upgrading bool
+
+	heartbeat *time.Timer
+	done      chan struct{} // closed if the session ends
+	polled    chan struct{} // closed if the poll loop stops
+	once      sync.Once
+}
+
+// start starts reading from the transport and probing websocket. packets
+// are handled before reading.
+func (s *session) start(packets []parser.Packet) {
+	switch t := s.transport.(type) {
+	case *pollingTransport:
+		go s.poll(t, packets)
+		if s.upgrade {
+			go s.probe()
+		}
+
+	case *websocketTransport:
+		close(s.polled)
+		go s.read(t, packets)
+	}
+}
+
+// send sends p with the current transport.
+func (s *session) send(p parser.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return ErrNotConnected
+	default:
+	}
+	return s.transport.send(p)
+}
+
+// close closes the transport and notifies the client.
+func (s *session) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.heartbeat.Stop()
+
+		s.mu.Lock()
+		t := s.transport
+		s.mu.Unlock()
+		t.close()
+
+		s.client.lost(s)
+	})
+}
+
+func (s *session) onPacket(p parser.Packet) {
+	switch p.Type {
+	case parser.Ping:
+		s.heartbeat.Reset(s.pingInterval + s.pingTimeout)
+		if err := s.send(parser.Packet{Type: parser.Pong}); err != nil {
+			s.close()
+		}
+
+	case parser.Message:
+		s.client.deliver(p.Data)
+
+	case parser.Close:
+		s.close()
+	}
+}
+
+// poll polls until the session ends or gets upgraded.
+func (s *session) poll(t *pollingTransport, packets []parser.Packet) {
+	defer close(s.polled)
+
+	for {
+		for _, p := range packets {
+			s.onPacket(p)
+		}
+
+		s.mu.Lock()
+		upgrading := s.upgrading
+		s.mu.Unlock()
+		if upgrading {
+			return
+		}
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		var err error
+		if packets, err = t.poll(); err != nil {
+			s.mu.Lock()
+			upgrading = s.upgrading
+			s.mu.Unlock()
+
+			// the poll has been canceled by the upgrade
+			if !upgrading {
+				s.close()
+			}
+			return
+		}
+	}
+}
+
+// read reads from the websocket until the session ends.
+func (s *session) read(t *websocketTransport, packets []parser.Packet) {
+	for _, p := range packets {
+		s.onPacket(p)
+	}
+
+	for {
+		p, err := t.receive()
+		if err != nil {
+			s.close()
+			return
+		}
+		s.onPacket(p)
+	}
+}
+
+// probe probes the websocket transport and upgrades the session. If the
+// probe fails, polling continues.
+func (s *session) probe() {
+	t, err := dialWebsocket(s.url, s.client.config.Header)
+	if err != nil {
+		return
+	}
+
+	if err = t.send(parser.Packet{Type: parser.Ping, Data: []byte("probe")}); err != nil {
+		t.close()
+		return
+	}
+	p, err := t.receive()
+	if err != nil || p.Type != parser.Pong || string(p.Data) != "probe" {
+		t.close()
+		return
+	}
+
+	// pause polling; the server ends the pending poll, otherwise it is
+	// canceled after a ping interval.
+	s.mu.Lock()
+	s.upgrading = true
+	s.mu.Unlock()
+
+	select {
+	case <-s.polled:
+	case <-time.After(s.pingInterval):
+		s.mu.Lock()
+		s.transport.close()
+		s.mu.Unlock()
+		<-s.polled
+	case <-s.done:
+		t.close()
+		return
+	}
+
+	if err = t.send(parser.Packet{Type: parser.Upgrade}); err != nil {
+		t.close()
+		s.close()
+		return
+	}
+
+	s.mu.Lock()
+	s.transport = t
+	s.mu.Unlock()
+
+	// the session might have been closed with the polling transport
+	select {
+	case <-s.done:
+		t.close()
+		return
+	default:
+	}
+	s.read(t, nil)
+}
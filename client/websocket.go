@@ -0,0 +1,76 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package client
+
+import (
+	"net/http"
+	"net/url"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/massiveart/engineio/internal/frame"
+	"github.com/massiveart/engineio/parser"
+)
+
+type websocketTransport struct {
+	conn *websocket.Conn
+}
+
+// dialWebsocket opens a websocket to the engine.io endpoint u.
+func dialWebsocket(u *url.URL, header http.Header) (*websocketTransport, error) {
+	origin := *u
+	origin.Path, origin.RawQuery = "", ""
+
+	location := *u
+	query := location.Query()
+	query.Set("transport", "websocket")
+	location.RawQuery = query.Encode()
+	switch u.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	default:
+		location.Scheme = "ws"
+	}
+
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		config.Header[key] = values
+	}
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &websocketTransport{conn: conn}, nil
+}
+
+func (t *websocketTransport) send(packets ...parser.Packet) error {
+	for _, p := range packets {
+		err := frame.Codec.Send(t.conn, frame.Frame{
+			Data:   parser.EncodeFrame(p, parser.Protocol4),
+			Binary: p.Binary,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive returns the next packet.
+func (t *websocketTransport) receive() (parser.Packet, error) {
+	var f frame.Frame
+	if err := frame.Codec.Receive(t.conn, &f); err != nil {
+		return parser.Packet{}, err
+	}
+	return parser.DecodeFrame(f.Data, f.Binary, parser.Protocol4)
+}
+
+func (t *websocketTransport) close() error {
+	return t.conn.Close()
+}
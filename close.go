@@ -0,0 +1,72 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import "sync"
+
+// CloseCode identifies why a session was closed.
+type CloseCode int
+
+const (
+	ForcedClose CloseCode = iota
+	ClientClose
+	TransportClose
+	TransportError
+	PingTimeout
+	ServerShutdown
+	QueueOverflow
+	PayloadTooLarge
+)
+
+var closeMessages = map[CloseCode]string{
+	ForcedClose:     "forced close",
+	ClientClose:     "client close",
+	TransportClose:  "transport close",
+	TransportError:  "transport error",
+	PingTimeout:     "ping timeout",
+	ServerShutdown:  "server shutting down",
+	QueueOverflow:   "queue overflow",
+	PayloadTooLarge: "payload too large",
+}
+
+// CloseReason describes why a session was closed.
+type CloseReason struct {
+	Code CloseCode
+	Err  error // underlying error, may be nil
+}
+
+func (r *CloseReason) String() string {
+	if r.Err != nil {
+		return closeMessages[r.Code] + ": " + r.Err.Error()
+	}
+	return closeMessages[r.Code]
+}
+
+// closer holds the close reason of a connection.
+type closer struct {
+	mu     sync.Mutex // protects reason
+	reason *CloseReason
+}
+
+// CloseReason returns the reason the connection was closed with, or nil
+// if it is not closed.
+func (c *closer) CloseReason() *CloseReason {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
+
+// setReason sets the close reason of code caused by err, unless a reason
+// is set already, and returns the reason set.
+func (c *closer) setReason(code CloseCode, err error) *CloseReason {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reason == nil {
+		c.reason = &CloseReason{Code: code, Err: err}
+	}
+	return c.reason
+}
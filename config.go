@@ -7,10 +7,18 @@ package engineio
 
 const DefaultEngineioPath = "/engine.io/"
 
+// Available transports.
+const (
+	TransportPolling   = "polling"
+	TransportWebsocket = "websocket"
+)
+
 type Config struct {
 	// Maximum amount of messages to store for a connection. If a
 	// connection has QueueLength amount of undelivered messages,
-	// the following writes will return ErrQueueFull error.
+	// the following writes will return ErrQueueFull error. It is also
+	// the amount of inbound messages buffered for ReadMessage, further
+	// messages are dropped until they are read.
 	QueueLength int
 
 	// The size of the read buffer in bytes.
@@ -22,13 +30,102 @@ type Config struct {
 	// Ping timeout in milliseconds.
 	PingTimeout int64
 
-	// Upgrades to use. (Only websocket supported).
+	// Maximum size in bytes of a polling payload or websocket frame
+	// received from a client, advertised to protocol v4 clients.
+	// Sessions exceeding it are closed. If zero, 1 MB is used.
+	MaxPayload int64
+
+	// Polling duration in milliseconds. A long poll without pending
+	// messages is answered with a noop packet after this duration. If
+	// zero, PingInterval is used.
+	PollingDuration int64
+
+	// Upgrade timeout in milliseconds. An upgrade not completed within
+	// this duration is cancelled, the session goes on with the previous
+	// transport. If zero, 10 seconds are used.
+	UpgradeTimeout int64
+
+	// Upgrades to use. (Only websocket supported). Upgrades to
+	// transports not allowed by Transports are not advertised.
 	Upgrades []string
+
+	// Transports allowed to connect with, selected by the transport
+	// query parameter. If nil, all transports are allowed.
+	Transports []string
+
+	// CORS configures cross-origin requests. If nil, no CORS headers
+	// are sent and preflight requests are rejected.
+	CORS *CORS
+
+	// InitialPacket is message data sent along with the open packet on
+	// handshake. If nil, no initial message is sent.
+	InitialPacket []byte
+
+	// Cookie configures the session cookie set on handshake. If nil,
+	// no cookie is set.
+	Cookie *Cookie
+
+	// AllowOrigin reports if websocket and JSONP requests from origin
+	// are allowed. If nil, websocket requests must send an origin and
+	// JSONP requests are not checked.
+	AllowOrigin OriginFunc
+
+	// AuthFunc authorizes handshake requests. If nil, every handshake
+	// is accepted.
+	AuthFunc AuthFunc
+
+	// SessionStore holds the sessions. If nil, a new in-memory store
+	// is used for each EngineIO.
+	SessionStore SessionStore
+
+	// Adapter manages rooms and delivers broadcasts. If nil, a new
+	// in-process adapter is used for each EngineIO. Use a pub/sub
+	// adapter to broadcast across several nodes.
+	Adapter Adapter
 }
 
 var DefaultConfig = &Config{
 	QueueLength:  10,
 	PingInterval: 25000,
 	PingTimeout:  60000,
-	Upgrades:     []string{"websocket"},
+	Upgrades:     []string{TransportWebsocket},
+	Transports:   []string{TransportPolling, TransportWebsocket},
+}
+
+// pollingDuration returns the long poll duration in milliseconds.
+func (c *Config) pollingDuration() int64 {
+	if c.PollingDuration > 0 {
+		return c.PollingDuration
+	}
+	return c.PingInterval
+}
+
+// maxPayload returns the maximum payload size in bytes.
+func (c *Config) maxPayload() int64 {
+	if c.MaxPayload > 0 {
+		return c.MaxPayload
+	}
+	return 1000000
+}
+
+// upgradeTimeout returns the upgrade timeout in milliseconds.
+func (c *Config) upgradeTimeout() int64 {
+	if c.UpgradeTimeout > 0 {
+		return c.UpgradeTimeout
+	}
+	return 10000
+}
+
+// allowed reports if transport is allowed by the config.
+func (c *Config) allowed(transport string) bool {
+	if c.Transports == nil {
+		return transport == TransportPolling || transport == TransportWebsocket
+	}
+
+	for _, t := range c.Transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
 }
@@ -6,18 +6,118 @@
 package engineio
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"sync"
 )
 
+// Connection is the session of a client. It is kept when the session
+// upgrades its transport.
 type Connection interface {
-	io.WriteCloser
+	io.ReadWriteCloser
 	ID() string
 
-	upgrade(packet) error
-	encode(packet) []byte
+	// ReadMessage returns the next inbound message. Inbound messages are
+	// buffered only if no message callback is set, messages arriving
+	// while QueueLength messages are unread are dropped. ReadMessage
+	// blocks until a message arrives or returns io.EOF once the
+	// connection is closed. Read reads the data of the inbound messages,
+	// without preserving message boundaries.
+	ReadMessage() ([]byte, error)
+
+	// WriteBinary writes data as a binary message.
+	WriteBinary(data []byte) (int, error)
+
+	// Get returns the value attached to the session for key, or nil.
+	Get(key string) interface{}
+
+	// Set attaches value to the session for key. Values are kept on
+	// upgrade and released once the session is closed.
+	Set(key string, value interface{})
+
+	// Transport returns the name of the transport in use, e.g.
+	// TransportPolling.
+	Transport() string
+
+	// RemoteAddr returns the network address of the client that sent
+	// the handshake request.
+	RemoteAddr() string
+
+	// Request returns a copy of the handshake request of the session,
+	// without body and with a background context.
+	Request() *http.Request
+
+	// CloseReason returns the reason the connection was closed with, or
+	// nil if it is not closed.
+	CloseReason() *CloseReason
+
+	shutdown(context.Context) error
 	handle(http.ResponseWriter, *http.Request) error
+	push([]byte) error
+}
+
+// Direction is the direction of a packet.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+// values holds the values attached to a session.
+type values struct {
+	mu sync.RWMutex // protects m
+	m  map[string]interface{}
+}
+
+func newValues(m map[string]interface{}) *values {
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	return &values{m: m}
+}
+
+func (v *values) Get(key string) interface{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.m[key]
+}
+
+func (v *values) Set(key string, value interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.m != nil {
+		v.m[key] = value
+	}
+}
+
+// release drops all values, following sets are ignored.
+func (v *values) release() {
+	v.mu.Lock()
+	v.m = nil
+	v.mu.Unlock()
+}
+
+// metadata holds the handshake request of a session.
+type metadata struct {
+	req *http.Request
+}
+
+// newMetadata returns the metadata of the handshake request req. req is
+// copied without its body, which holds on to the response writer.
+func newMetadata(req *http.Request) metadata {
+	r := req.Clone(context.Background())
+	r.Body = http.NoBody
+	r.GetBody = nil
+	return metadata{r}
+}
+
+func (m metadata) Request() *http.Request {
+	return m.req
+}
 
-	messageFunc(func(Connection, []byte) error)
-	closeFunc(func(Connection))
+func (m metadata) RemoteAddr() string {
+	return m.req.RemoteAddr
 }
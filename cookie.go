@@ -0,0 +1,46 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import "net/http"
+
+// Cookie configures the session cookie set on handshake, which holds the
+// session id for sticky-session load balancers.
+type Cookie struct {
+	// Name of the cookie. If empty, "io" is used.
+	Name string
+
+	// Path of the cookie. If empty, "/" is used.
+	Path string
+
+	HttpOnly bool
+	Secure   bool
+	SameSite http.SameSite
+
+	// MaxAge is the lifetime of the cookie in seconds. If zero, a
+	// session cookie is set.
+	MaxAge int
+}
+
+// cookie returns the cookie holding sid.
+func (c *Cookie) cookie(sid string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    sid,
+		Path:     c.Path,
+		HttpOnly: c.HttpOnly,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
+		MaxAge:   c.MaxAge,
+	}
+	if cookie.Name == "" {
+		cookie.Name = "io"
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	return cookie
+}
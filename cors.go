@@ -0,0 +1,87 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS configures cross-origin handshake and polling requests.
+type CORS struct {
+	// Origins allowed to connect, "*" allows any origin. Origins allowed
+	// by "*" only are answered with "*", which browsers never grant
+	// credentials.
+	AllowedOrigins []string
+
+	// AllowOriginFunc reports if origin is allowed to connect. If set,
+	// AllowedOrigins is ignored.
+	AllowOriginFunc func(origin string) bool
+
+	// AllowCredentials allows requests with cookies or http
+	// authentication.
+	AllowCredentials bool
+
+	// Request headers allowed besides Content-Type.
+	AllowedHeaders []string
+
+	// MaxAge is the duration in seconds preflight responses may be
+	// cached. If zero, no max age is sent.
+	MaxAge int
+}
+
+// allowOrigin returns the allowed origin header of origin, which is
+// empty if origin is not allowed.
+func (c *CORS) allowOrigin(origin string) string {
+	if c.AllowOriginFunc != nil {
+		if c.AllowOriginFunc(origin) {
+			return origin
+		}
+		return ""
+	}
+
+	wildcard := false
+	for _, o := range c.AllowedOrigins {
+		if o == origin {
+			return origin
+		}
+		wildcard = wildcard || o == "*"
+	}
+	if wildcard {
+		return "*"
+	}
+	return ""
+}
+
+// handle sets the CORS headers of the response to req. It returns true
+// if req is a preflight request, which is answered.
+func (c *CORS) handle(w http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if allowed := c.allowOrigin(origin); origin != "" && allowed != "" {
+		h.Set("Access-Control-Allow-Origin", allowed)
+		if c.AllowCredentials && allowed != "*" {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", "GET, POST")
+			h.Set("Access-Control-Allow-Headers", strings.Join(append([]string{"Content-Type"}, c.AllowedHeaders...), ", "))
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+		}
+	}
+
+	if preflight {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
@@ -0,0 +1,86 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	config := *DefaultConfig
+	config.CORS = &CORS{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Authorization"},
+		MaxAge:           600,
+	}
+	e := NewEngineIO(&config)
+
+	url := DefaultEngineioPath + "?EIO=4&transport=polling"
+	req := httptest.NewRequest("OPTIONS", url, nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	h := rec.Header()
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight: expect status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if h.Get("Access-Control-Allow-Origin") != "https://example.com" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("preflight: expect origin with credentials, got %v", h)
+	}
+	if h.Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("preflight: expect allowed headers and max age, got %v", h)
+	}
+
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Origin", "https://example.com")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Body.String(), "0{") || rec.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Fatalf("handshake: expect open packet with origin, got %q %v", rec.Body.String(), rec.Header())
+	}
+
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Origin", "https://evil.com")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if o := rec.Header().Get("Access-Control-Allow-Origin"); o != "" {
+		t.Fatalf("handshake: expect no allowed origin, got %q", o)
+	}
+	e.Close()
+}
+
+func TestCORSWildcard(t *testing.T) {
+	c := &CORS{
+		AllowedOrigins:   []string{"*", "https://example.com"},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		origin      string
+		allowed     string
+		credentials string
+	}{
+		{"https://evil.com", "*", ""},
+		{"https://example.com", "https://example.com", "true"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", DefaultEngineioPath, nil)
+		req.Header.Set("Origin", test.origin)
+		rec := httptest.NewRecorder()
+		c.handle(rec, req)
+
+		h := rec.Header()
+		if h.Get("Access-Control-Allow-Origin") != test.allowed || h.Get("Access-Control-Allow-Credentials") != test.credentials {
+			t.Fatalf("%s: expect origin %q with credentials %q, got %v", test.origin, test.allowed, test.credentials, h)
+		}
+	}
+}
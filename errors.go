@@ -0,0 +1,86 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorCode is an engine.io protocol error code.
+type ErrorCode int
+
+const (
+	UnknownTransport ErrorCode = iota
+	UnknownSid
+	BadHandshakeMethod
+	BadRequest
+	Forbidden
+	UnsupportedProtocolVersion
+)
+
+var errorMessages = map[ErrorCode]string{
+	UnknownTransport:           "Transport unknown",
+	UnknownSid:                 "Session ID unknown",
+	BadHandshakeMethod:         "Bad handshake method",
+	BadRequest:                 "Bad request",
+	Forbidden:                  "Forbidden",
+	UnsupportedProtocolVersion: "Unsupported protocol version",
+}
+
+// Error is a request error answered with a protocol error code.
+type Error struct {
+	Code    ErrorCode
+	Message string // message sent to the client
+	Status  int    // http status code
+	Err     error  // underlying error, may be nil
+}
+
+// newError returns a new Error of code caused by err.
+func newError(code ErrorCode, err error) *Error {
+	status := http.StatusBadRequest
+	if code == Forbidden {
+		status = http.StatusForbidden
+	}
+
+	return &Error{
+		Code:    code,
+		Message: errorMessages[code],
+		Status:  status,
+		Err:     err,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// requestError answers req with err as json object and invokes the error
+// callback.
+func (e *EngineIO) requestError(w http.ResponseWriter, req *http.Request, err *Error) {
+	if e.errorFunc != nil {
+		e.errorFunc(req, err)
+	}
+
+	data, _ := json.Marshal(struct {
+		Code    ErrorCode `json:"code"`
+		Message string    `json:"message"`
+	}{err.Code, err.Message})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+	w.Write(data)
+}
+
+// ErrorFunc sets fn to be invoked when a request is answered with an
+// error. It passes the request along with the error as arguments to the
+// callback.
+func (e *EngineIO) ErrorFunc(fn func(*http.Request, *Error)) {
+	e.errorFunc = fn
+}
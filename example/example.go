@@ -52,16 +52,10 @@ func main() {
 		return err
 	})
 
-	enio.CloseFunc(func(conn engineio.Connection) {})
+	enio.CloseFunc(func(conn engineio.Connection, reason *engineio.CloseReason) {})
 
 	server := http.NewServeMux()
-	handler := func() func(w http.ResponseWriter, req *http.Request) {
-		return func(w http.ResponseWriter, req *http.Request) {
-			enio.Handler(w, req, nil)
-		}
-	}
-
-	server.HandleFunc(engineio.DefaultEngineioPath, handler())
+	server.Handle(engineio.DefaultEngineioPath, enio)
 	server.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		w.Write(page)
 	})
@@ -0,0 +1,95 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/massiveart/engineio/parser"
+)
+
+// heartbeat tracks the liveness of a session. Protocol v4 clients are
+// pinged by the server every ping interval, older clients ping on their
+// own. A session is lost if no packet arrives within the ping interval
+// plus the ping timeout.
+type heartbeat struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	ping   func() error // sends a ping, nil if the client pings
+	expire func()       // invoked once the session is lost
+
+	beats chan struct{}
+	done  chan struct{}
+	once  sync.Once // guards stop
+}
+
+// newHeartbeat starts and returns a new heartbeat. interval and timeout
+// are in milliseconds.
+func newHeartbeat(interval, timeout time.Duration, protocol int, ping func() error, expire func()) *heartbeat {
+	h := &heartbeat{
+		interval: interval * time.Millisecond,
+		timeout:  timeout * time.Millisecond,
+		expire:   expire,
+		beats:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if protocol >= parser.Protocol4 {
+		h.ping = ping
+	}
+
+	go h.run()
+	return h
+}
+
+func (h *heartbeat) run() {
+	var pings <-chan time.Time
+	if h.ping != nil {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	timer := time.NewTimer(h.interval + h.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-pings:
+			// a failed ping is left to the ping timeout
+			h.ping()
+
+		case <-h.beats:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(h.interval + h.timeout)
+
+		case <-timer.C:
+			h.expire()
+			return
+
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// beat signals that a packet arrived from the client.
+func (h *heartbeat) beat() {
+	select {
+	case h.beats <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the heartbeat without expiring the session.
+func (h *heartbeat) stop() {
+	h.once.Do(func() {
+		close(h.done)
+	})
+}
@@ -0,0 +1,75 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+// Package frame sends and receives the websocket frames of server and
+// client.
+package frame
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// ErrTooLarge is returned by Receive if a frame exceeds its limit.
+var ErrTooLarge = errors.New("frame too large")
+
+// Frame is a websocket frame along with its payload type.
+type Frame struct {
+	Data   []byte
+	Binary bool
+}
+
+// Codec sends and receives websocket frames, preserving their payload
+// type.
+var Codec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		f := v.(Frame)
+		if f.Binary {
+			return f.Data, websocket.BinaryFrame, nil
+		}
+		return f.Data, websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		f := v.(*Frame)
+		f.Data = data
+		f.Binary = payloadType == websocket.BinaryFrame
+		return nil
+	},
+}
+
+// Receive receives the next data frame from ws, control frames are
+// handled along the way. At most max bytes of payload are read, larger
+// frames are rejected with ErrTooLarge; if max is zero, payloads are not
+// limited. ws must not be read concurrently.
+func Receive(ws *websocket.Conn, max int64) (Frame, error) {
+	for {
+		r, err := ws.NewFrameReader()
+		if err != nil {
+			return Frame{}, err
+		}
+		if r, err = ws.HandleFrame(r); err != nil {
+			return Frame{}, err
+		}
+		if r == nil {
+			continue
+		}
+
+		f := Frame{Binary: r.PayloadType() == websocket.BinaryFrame}
+		var payload io.Reader = r
+		if max > 0 {
+			payload = io.LimitReader(r, max+1)
+		}
+		if f.Data, err = ioutil.ReadAll(payload); err != nil {
+			return Frame{}, err
+		}
+		if max > 0 && int64(len(f.Data)) > max {
+			return Frame{}, ErrTooLarge
+		}
+		return f, nil
+	}
+}
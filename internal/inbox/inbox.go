@@ -0,0 +1,100 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+// Package inbox buffers the inbound messages of server sessions and
+// clients.
+package inbox
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrClosed is returned by Push once the inbox is closed.
+var ErrClosed = errors.New("inbox closed")
+
+// Inbox buffers inbound messages.
+type Inbox struct {
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+
+	mu  sync.Mutex // protects buf
+	buf []byte     // unread data of the current message
+}
+
+// New returns a new Inbox buffering up to size messages.
+func New(size int) *Inbox {
+	return &Inbox{
+		messages: make(chan []byte, size),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Push buffers data. If the buffer is full, data is dropped; Push never
+// blocks the transport the message arrived on.
+func (b *Inbox) Push(data []byte) error {
+	select {
+	case <-b.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case b.messages <- data:
+	default:
+	}
+	return nil
+}
+
+// ReadMessage returns the next message. It blocks until a message arrives
+// or the inbox is closed, in which case io.EOF is returned once all
+// buffered messages are read.
+func (b *Inbox) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-b.messages:
+		return data, nil
+	default:
+	}
+
+	select {
+	case data := <-b.messages:
+		return data, nil
+	case <-b.closed:
+		select {
+		case data := <-b.messages:
+			return data, nil
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+// Read reads the data of the inbound messages, message boundaries are
+// not preserved.
+func (b *Inbox) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.buf) == 0 {
+		data, err := b.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		b.buf = data
+	}
+
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// Close closes the inbox, following pushes fail.
+func (b *Inbox) Close() {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+}
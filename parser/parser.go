@@ -0,0 +1,333 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+// Package parser implements the engine.io packet and payload encoding.
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Protocol revisions. Revision 2 and 3 share the same payload framing,
+// revision 4 separates the packets of a payload by a record separator.
+const (
+	Protocol2 = 2
+	Protocol3 = 3
+	Protocol4 = 4
+)
+
+// Packet types.
+const (
+	Open    = "0"
+	Close   = "1"
+	Ping    = "2"
+	Pong    = "3"
+	Message = "4"
+	Upgrade = "5"
+	Noop    = "6"
+)
+
+// Binary payload (XHR2) framing bytes used by protocol v2 and v3.
+const (
+	stringFrame byte = 0x00
+	binaryFrame byte = 0x01
+	lengthEnd   byte = 0xff
+)
+
+// Packet is a single engine.io packet.
+type Packet struct {
+	Type   string
+	Data   []byte
+	Binary bool // indicates if Data is binary
+}
+
+var (
+	packetType = map[byte]string{
+		'0': Open,
+		'1': Close,
+		'2': Ping,
+		'3': Pong,
+		'4': Message,
+		'5': Upgrade,
+		'6': Noop,
+	}
+
+	sep       = []byte(":")
+	recordSep = []byte("\x1e")
+)
+
+// DecodePayload decodes the polling payload according to the protocol
+// revision.
+func DecodePayload(data []byte, protocol int) ([]Packet, error) {
+	if protocol >= Protocol4 {
+		return decodeV4(data)
+	}
+	if len(data) > 0 && (data[0] == stringFrame || data[0] == binaryFrame) {
+		return decodeBinary(data)
+	}
+	return decode(data)
+}
+
+// decode decodes the polling payload. decode accepts packetType
+// only. Packet lengths are counted in characters, as they are encoded.
+func decode(data []byte) ([]Packet, error) {
+	packets := make([]Packet, 0)
+
+	for {
+		i := bytes.Index(data, sep)
+		if i == -1 {
+			return nil, fmt.Errorf("short read")
+		}
+		n, err := strconv.Atoi(string(data[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("ignoring payload")
+		}
+
+		data = data[i+1:]
+		if len(data) == 0 {
+			return nil, fmt.Errorf("short read")
+		}
+		end := runeOffset(data, n)
+		if n <= 0 || end == -1 {
+			return nil, fmt.Errorf("malformed packet")
+		}
+		p, err := decodeText(data[:end], Protocol3)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+
+		if len(data) == end {
+			break
+		}
+		data = data[end:]
+	}
+
+	return packets, nil
+}
+
+// runeOffset returns the byte offset following the first n characters of
+// data, or -1 if data is shorter.
+func runeOffset(data []byte, n int) int {
+	offset := 0
+	for ; n > 0; n-- {
+		if offset == len(data) {
+			return -1
+		}
+		_, size := utf8.DecodeRune(data[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// decodeBinary decodes the binary (XHR2) polling payload of protocol v2
+// and v3.
+func decodeBinary(data []byte) ([]Packet, error) {
+	packets := make([]Packet, 0)
+
+	for len(data) > 0 {
+		binary := data[0] == binaryFrame
+		i := bytes.IndexByte(data, lengthEnd)
+		if i == -1 || i == 1 {
+			return nil, fmt.Errorf("short read")
+		}
+		n := 0
+		for _, d := range data[1:i] {
+			if d > 9 {
+				return nil, fmt.Errorf("ignoring payload")
+			}
+			n = n*10 + int(d)
+			// bounds n, which can't overflow this way
+			if n > len(data) {
+				return nil, fmt.Errorf("malformed packet")
+			}
+		}
+
+		data = data[i+1:]
+		if n == 0 || len(data) < n {
+			return nil, fmt.Errorf("malformed packet")
+		}
+
+		var (
+			p   Packet
+			err error
+		)
+		if binary {
+			p, err = DecodeFrame(data[:n], true, Protocol3)
+		} else {
+			p, err = decodeText(data[:n], Protocol3)
+		}
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+		data = data[n:]
+	}
+
+	return packets, nil
+}
+
+// decodeV4 decodes the protocol v4 polling payload. decodeV4 accepts
+// packetType only.
+func decodeV4(data []byte) ([]Packet, error) {
+	packets := make([]Packet, 0)
+
+	for _, d := range bytes.Split(data, recordSep) {
+		p, err := decodeText(d, Protocol4)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+
+	return packets, nil
+}
+
+// decodeText decodes a single text encoded packet. Binary packets are
+// base64 encoded and prefixed with a 'b'.
+func decodeText(data []byte, protocol int) (Packet, error) {
+	if len(data) == 0 {
+		return Packet{}, fmt.Errorf("short read")
+	}
+
+	if data[0] != 'b' {
+		t, found := packetType[data[0]]
+		if !found {
+			return Packet{}, fmt.Errorf("unknown packet type")
+		}
+		return Packet{Type: t, Data: data[1:]}, nil
+	}
+
+	// protocol v4 encodes binary messages without a packet type
+	t, data := Message, data[1:]
+	if protocol < Protocol4 {
+		if len(data) == 0 {
+			return Packet{}, fmt.Errorf("short read")
+		}
+		var found bool
+		if t, found = packetType[data[0]]; !found {
+			return Packet{}, fmt.Errorf("unknown packet type")
+		}
+		data = data[1:]
+	}
+
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(buf, data)
+	if err != nil {
+		return Packet{}, err
+	}
+	return Packet{Type: t, Data: buf[:n], Binary: true}, nil
+}
+
+// DecodeFrame decodes a single websocket frame. Binary frames of
+// protocol v2 and v3 carry the packet type as a number in the first
+// byte, binary frames of protocol v4 are messages.
+func DecodeFrame(data []byte, binary bool, protocol int) (Packet, error) {
+	if !binary {
+		return decodeText(data, protocol)
+	}
+
+	if protocol >= Protocol4 {
+		return Packet{Type: Message, Data: data, Binary: true}, nil
+	}
+
+	if len(data) == 0 {
+		return Packet{}, fmt.Errorf("short read")
+	}
+	t, found := packetType['0'+data[0]]
+	if !found {
+		return Packet{}, fmt.Errorf("unknown packet type")
+	}
+	return Packet{Type: t, Data: data[1:], Binary: true}, nil
+}
+
+// EncodePayload encodes packets into a polling payload according to the
+// protocol revision. If b64 is false and a binary packet is present, a
+// binary (XHR2) payload is encoded for protocol v2 and v3 clients.
+// EncodePayload reports whether the encoded payload is binary.
+func EncodePayload(packets []Packet, protocol int, b64 bool) ([]byte, bool) {
+	if !b64 && protocol < Protocol4 {
+		for _, p := range packets {
+			if p.Binary {
+				return encodeBinary(packets), true
+			}
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	for i, p := range packets {
+		data := encodeText(p, protocol)
+		if protocol >= Protocol4 {
+			if i > 0 {
+				buf.Write(recordSep)
+			}
+			buf.Write(data)
+			continue
+		}
+
+		fmt.Fprintf(buf, "%d:", utf8.RuneCount(data))
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), false
+}
+
+// encodeBinary encodes packets into a binary (XHR2) polling payload of
+// protocol v2 and v3.
+func encodeBinary(packets []Packet) []byte {
+	buf := bytes.NewBuffer(nil)
+
+	for _, p := range packets {
+		var data []byte
+		if p.Binary {
+			buf.WriteByte(binaryFrame)
+			data = EncodeFrame(p, Protocol3)
+		} else {
+			buf.WriteByte(stringFrame)
+			data = encodeText(p, Protocol3)
+		}
+
+		for _, d := range strconv.Itoa(len(data)) {
+			buf.WriteByte(byte(d - '0'))
+		}
+		buf.WriteByte(lengthEnd)
+		buf.Write(data)
+	}
+
+	return buf.Bytes()
+}
+
+// encodeText encodes a single packet as text. Binary packets are base64
+// encoded and prefixed with a 'b'.
+func encodeText(p Packet, protocol int) []byte {
+	if !p.Binary {
+		return append([]byte(p.Type), p.Data...)
+	}
+
+	data := []byte("b")
+	if protocol < Protocol4 {
+		data = append(data, p.Type...)
+	}
+	return append(data, base64.StdEncoding.EncodeToString(p.Data)...)
+}
+
+// EncodeFrame encodes a single packet as websocket frame. Binary frames
+// of protocol v2 and v3 carry the packet type as a number in the first
+// byte, binary frames of protocol v4 are messages.
+func EncodeFrame(p Packet, protocol int) []byte {
+	if !p.Binary {
+		return encodeText(p, protocol)
+	}
+
+	if protocol >= Protocol4 {
+		return p.Data
+	}
+	return append([]byte{p.Type[0] - '0'}, p.Data...)
+}
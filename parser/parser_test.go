@@ -0,0 +1,240 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketDecode(t *testing.T) {
+	data := []byte("8:4aaaaaaa")
+	packets, err := decode(data)
+	if err != nil {
+		t.Fatalf("decode 1: %v", err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("decode 1: expect 1 packet, got %d", len(packets))
+	}
+	if packets[0].Type != "4" {
+		t.Fatalf("decode 1: expect packet type \"4\", got %q", packets[0].Type)
+	}
+	if bytes.Compare(packets[0].Data, []byte("aaaaaaa")) != 0 {
+		t.Fatalf("decode 1: expect packet data \"aaaaaaa\", got %q", packets[0].Data)
+	}
+
+	data = []byte("8:4aaaaaaa10:4xxxxxxxxx4:2bbb")
+	packets, err = decode(data)
+	if err != nil {
+		t.Fatalf("decode 3: %v", err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("decode 3: expect 3 packets, got %d", len(packets))
+	}
+
+	for i, ty := range []string{"4", "4", "2"} {
+		if packets[i].Type != ty {
+			t.Fatalf("decode: expect packet type %q, got %q", ty, packets[i].Type)
+		}
+	}
+	for i, ty := range []string{"aaaaaaa", "xxxxxxxxx", "bbb"} {
+		if bytes.Compare(packets[0].Data, []byte("aaaaaaa")) != 0 {
+			t.Fatalf("decode: expect packet data %q, got %q", ty, packets[i].Data)
+		}
+	}
+
+	data = []byte(`15:4{"test":"aaa"}`)
+	packets, err = decode(data)
+	if err != nil {
+		t.Fatalf("decode 4: %v", err)
+	}
+
+	if len(packets) != 1 {
+		t.Fatalf("decode 4: expect 1 packet, got %d", len(packets))
+	}
+	if packets[0].Type != "4" {
+		t.Fatalf("decode 4: expect packet type \"4\", got %q", packets[0].Type)
+	}
+	if bytes.Compare(packets[0].Data, []byte(`{"test":"aaa"}`)) != 0 {
+		t.Fatalf("decode 4: expect packet data \"{\"test\":\"aaa\"}\", got %q", packets[0].Data)
+	}
+}
+
+func TestInvalidPacket(t *testing.T) {
+	data := []byte("8:4aaa")
+	_, err := decode(data)
+	if err == nil {
+		t.Fatalf("invalid 1: expected non nil err")
+	}
+
+	data = []byte("2:4aaaaaaaaaa")
+	_, err = decode(data)
+	if err == nil {
+		t.Fatalf("invalid 2: expected non nil err")
+	}
+
+	data = []byte("8:4aaa34:4aaa")
+	_, err = decode(data)
+	if err == nil {
+		t.Fatalf("invalid 3: expected non nil err")
+	}
+
+	data = []byte("3:4::::::::::")
+	_, err = decode(data)
+	if err == nil {
+		t.Fatalf("invalid 4: expected non nil err")
+	}
+
+	data = []byte("3:4")
+	_, err = decode(data)
+	if err == nil {
+		t.Fatalf("invalid 5: expected non nil err")
+	}
+
+	data = []byte("-1:4")
+	_, err = decode(data)
+	if err == nil {
+		t.Fatalf("invalid 6: expected non nil err")
+	}
+
+	data = append([]byte{stringFrame}, bytes.Repeat([]byte{9}, 20)...)
+	data = append(data, lengthEnd, '4')
+	_, err = decodeBinary(data)
+	if err == nil {
+		t.Fatalf("invalid 7: expected non nil err")
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	packets := []Packet{
+		{Type: Message, Data: []byte("é")},
+		{Type: Message, Data: []byte("日本 😀")},
+		{Type: Ping},
+	}
+	for _, protocol := range []int{Protocol3, Protocol4} {
+		data, _ := EncodePayload(packets, protocol, true)
+		decoded, err := DecodePayload(data, protocol)
+		if err != nil {
+			t.Fatalf("round trip v%d: %v", protocol, err)
+		}
+		if len(decoded) != len(packets) {
+			t.Fatalf("round trip v%d: expect %d packets, got %d", protocol, len(packets), len(decoded))
+		}
+		for i, p := range packets {
+			if decoded[i].Type != p.Type || !bytes.Equal(decoded[i].Data, p.Data) {
+				t.Fatalf("round trip v%d: expect packet %+v, got %+v", protocol, p, decoded[i])
+			}
+		}
+	}
+}
+
+func TestPacketDecodeV4(t *testing.T) {
+	data := []byte("4aaaaaaa\x1e4xxxxxxxxx\x1e3")
+	packets, err := DecodePayload(data, Protocol4)
+	if err != nil {
+		t.Fatalf("decode v4: %v", err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("decode v4: expect 3 packets, got %d", len(packets))
+	}
+
+	for i, ty := range []string{"4", "4", "3"} {
+		if packets[i].Type != ty {
+			t.Fatalf("decode v4: expect packet type %q, got %q", ty, packets[i].Type)
+		}
+	}
+	for i, d := range []string{"aaaaaaa", "xxxxxxxxx", ""} {
+		if bytes.Compare(packets[i].Data, []byte(d)) != 0 {
+			t.Fatalf("decode v4: expect packet data %q, got %q", d, packets[i].Data)
+		}
+	}
+
+	for i, data := range []string{"", "4a\x1e", "x"} {
+		if _, err = DecodePayload([]byte(data), Protocol4); err == nil {
+			t.Fatalf("invalid v4 %d: expected non nil err", i+1)
+		}
+	}
+}
+
+func TestPacketEncode(t *testing.T) {
+	packets := []Packet{
+		{Type: Message, Data: []byte("aaa")},
+		{Type: Ping},
+	}
+
+	data, _ := EncodePayload(packets, Protocol3, false)
+	if bytes.Compare(data, []byte("4:4aaa1:2")) != 0 {
+		t.Fatalf("encode v3: expect \"4:4aaa1:2\", got %q", data)
+	}
+
+	data, _ = EncodePayload(packets, Protocol4, false)
+	if bytes.Compare(data, []byte("4aaa\x1e2")) != 0 {
+		t.Fatalf("encode v4: expect \"4aaa\\x1e2\", got %q", data)
+	}
+}
+
+func TestBinaryPacket(t *testing.T) {
+	packets := []Packet{
+		{Type: Message, Data: []byte("aaa")},
+		{Type: Message, Data: []byte{0x00, 0xff}, Binary: true},
+	}
+
+	tests := []struct {
+		protocol int
+		b64      bool
+		data     string
+		binary   bool
+	}{
+		{Protocol3, true, "4:4aaa6:b4AP8=", false},
+		{Protocol3, false, "\x00\x04\xff4aaa\x01\x03\xff\x04\x00\xff", true},
+		{Protocol4, false, "4aaa\x1ebAP8=", false},
+	}
+
+	for i, test := range tests {
+		data, binary := EncodePayload(packets, test.protocol, test.b64)
+		if bytes.Compare(data, []byte(test.data)) != 0 {
+			t.Fatalf("binary %d: expect payload %q, got %q", i+1, test.data, data)
+		}
+		if binary != test.binary {
+			t.Fatalf("binary %d: expect binary %v, got %v", i+1, test.binary, binary)
+		}
+
+		decoded, err := DecodePayload(data, test.protocol)
+		if err != nil {
+			t.Fatalf("binary %d: %v", i+1, err)
+		}
+		if len(decoded) != 2 {
+			t.Fatalf("binary %d: expect 2 packets, got %d", i+1, len(decoded))
+		}
+		for j, p := range packets {
+			if decoded[j].Type != p.Type || decoded[j].Binary != p.Binary {
+				t.Fatalf("binary %d: expect packet %d type %q (binary %v), got %q (binary %v)",
+					i+1, j, p.Type, p.Binary, decoded[j].Type, decoded[j].Binary)
+			}
+			if bytes.Compare(decoded[j].Data, p.Data) != 0 {
+				t.Fatalf("binary %d: expect packet %d data %q, got %q", i+1, j, p.Data, decoded[j].Data)
+			}
+		}
+	}
+}
+
+func TestBinaryFrame(t *testing.T) {
+	p := Packet{Type: Message, Data: []byte{0x00, 0xff}, Binary: true}
+
+	for _, protocol := range []int{Protocol3, Protocol4} {
+		data := EncodeFrame(p, protocol)
+		decoded, err := DecodeFrame(data, true, protocol)
+		if err != nil {
+			t.Fatalf("frame v%d: %v", protocol, err)
+		}
+		if decoded.Type != Message || !decoded.Binary {
+			t.Fatalf("frame v%d: expect binary message, got %q (binary %v)", protocol, decoded.Type, decoded.Binary)
+		}
+		if bytes.Compare(decoded.Data, p.Data) != 0 {
+			t.Fatalf("frame v%d: expect data %q, got %q", protocol, p.Data, decoded.Data)
+		}
+	}
+}
@@ -6,33 +6,36 @@
 package engineio
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"sync"
 	"time"
-	"unicode/utf8"
+
+	"github.com/massiveart/engineio/parser"
 )
 
+// maxHeartbeat is the amount of queue slots reserved for heartbeat and
+// noop packets.
 const maxHeartbeat = 10
 
 type pollingWriter struct {
-	w         io.Writer
-	connected bool // indicates if the writer is ready for writing
-	done      chan<- bool
+	w    http.ResponseWriter
+	done chan<- bool
 }
 
-func (w *pollingWriter) Write(p []byte) (int, error) {
+// write writes the payload p. If contentType is not empty, it is set as
+// the content type of the response.
+func (w *pollingWriter) write(p []byte, contentType string) (int, error) {
 	defer func() {
 		w.done <- true
 	}()
 
-	if !w.connected {
-		return 0, ErrNotConnected
+	if contentType != "" {
+		w.w.Header().Set("Content-Type", contentType)
 	}
-
 	return w.w.Write(p)
 }
 
@@ -40,20 +43,28 @@ type pollingConn struct {
 	mu   sync.RWMutex // protects the connections queue/map
 	rwmu sync.Mutex   // protects the queue
 
-	sid         string
-	queue       chan packet
+	queue       chan parser.Packet
 	connected   bool // indicates if the connection has been disconnected
+	closing     bool // indicates if the connection is shutting down
 	upgraded    bool // indicates if the connection has been upgraded
 	index       int  // jsonp callback index (if jsonp is used)
+	protocol    int  // engine.io protocol revision
+	b64         bool // indicates if binary data has to be base64 encoded
+	maxPayload  int64
 	connNum     int64
 	connections map[int64]*pollingWriter
-
-	remove       chan<- string
-	pingInterval time.Duration
-	queueLength  int
-
-	messageFn func(Connection, []byte) error
-	closeFn   func(Connection)
+	writers     chan struct{} // closed if a polling writer is added
+	drained     chan struct{} // closed if the close packet is flushed
+	closed      chan struct{} // closed if the connection is closed
+
+	heartbeat       *heartbeat
+	pollingDuration time.Duration
+	queueLength     int
+
+	messageFn func(parser.Packet) error
+	closeFn   func(*CloseReason)
+	packetFn  func(parser.Packet, Direction)
+	drainFn   func()
 }
 
 // TODO: handle read/write timeout
@@ -61,47 +72,50 @@ type pollingConn struct {
 func (c *pollingConn) reader(dst io.Writer, req *http.Request) (err error) {
 	data := []byte{}
 	if c.index == -1 {
-		data, err = ioutil.ReadAll(req.Body)
+		data, err = ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, c.maxPayload))
+		if _, ok := err.(*http.MaxBytesError); ok {
+			c.close(PayloadTooLarge, err)
+			return ErrPayloadTooLarge
+		}
 		if err != nil {
 			return err
 		}
 	} else {
+		// the form is bounded by the max payload already
 		data = []byte(req.FormValue("d"))
 	}
 
-	packets, err := decode(data)
+	packets, err := parser.DecodePayload(data, c.protocol)
 	if err != nil {
 		return err
 	}
 
 	for _, p := range packets {
+		c.heartbeat.beat()
+		c.packet(p, Inbound)
+
 		switch p.Type {
-		case closeID:
-			return nil
+		case parser.Close:
+			return c.close(ClientClose, nil)
 
-		case pingID:
-			_, err = dst.Write(c.encode(packet{
-				index: c.index,
-				Type:  pongID,
-			}))
-			if err != nil {
+		case parser.Ping:
+			// the pong is sent with the long poll, clients ignore the
+			// response of a post
+			if err = c.heartbeatPacket(parser.Packet{Type: parser.Pong, Data: p.Data}); err != nil {
 				return err
 			}
 
-		case messageID:
-			if c.messageFunc != nil {
-				if err = c.messageFn(c, p.Data); err != nil {
+		case parser.Message:
+			if c.messageFn != nil {
+				if err = c.messageFn(p); err != nil {
 					// TODO
 				}
 			}
-
-			if _, err = dst.Write(okResponse); err != nil {
-				return err
-			}
 		}
 	}
-	return nil
 
+	_, err = dst.Write(okResponse)
+	return err
 }
 
 func (c *pollingConn) handle(w http.ResponseWriter, req *http.Request) (err error) {
@@ -116,216 +130,342 @@ func (c *pollingConn) handle(w http.ResponseWriter, req *http.Request) (err erro
 	// add a fresh polling writer
 	c.mu.Lock()
 
-	closeNotifier := w.(http.CloseNotifier).CloseNotify()
-	done := make(chan bool)
+	done := make(chan bool, 1)
 	c.connNum++
 	num := c.connNum
 
 	c.connections[c.connNum] = &pollingWriter{
-		w:         w,
-		done:      done,
-		connected: true,
+		w:    w,
+		done: done,
 	}
+	close(c.writers)
+	c.writers = make(chan struct{})
 
 	c.mu.Unlock()
 
+	// the long poll is answered with a noop packet if nothing else is
+	// written within the polling duration
+	timer := time.NewTimer(c.pollingDuration * time.Millisecond)
+	defer timer.Stop()
+
 	// handle write done, timeout or closed by user signals
+	written, closed := false, false
 Loop:
 	for {
 		select {
 		case <-done:
+			written = true
 			break Loop
 
-		case <-closeNotifier:
-			c.Close()
+		case <-c.closed:
+			closed = true
 			break Loop
 
-		case <-time.After(c.pingInterval * time.Millisecond):
-			select {
-			case c.queue <- packet{
-				connNum: num,
-				index:   c.index,
-				Type:    pongID,
-			}:
-			default:
+		case <-req.Context().Done():
+			c.close(TransportClose, nil)
+			break Loop
+
+		case <-timer.C:
+			c.rwmu.Lock()
+			if c.connected {
+				select {
+				case c.queue <- parser.Packet{Type: parser.Noop}:
+				default:
+				}
 			}
+			c.rwmu.Unlock()
 		}
 	}
 
-	// delete polling writer
+	// delete polling writer, if taken by the flusher wait for the
+	// write to finish
 	c.mu.Lock()
-	c.connections[num].connected = false
+	_, found := c.connections[num]
 	delete(c.connections, num)
 	c.mu.Unlock()
 
+	if !found && !written {
+		<-done
+	}
+
+	// answer the pending poll of the closed connection, an upgraded
+	// client goes on with websocket
+	if found && closed {
+		p := parser.Packet{Type: parser.Close}
+		c.rwmu.Lock()
+		if c.upgraded {
+			p.Type = parser.Noop
+		}
+		c.rwmu.Unlock()
+
+		if _, err = w.Write(c.encode(p)); err != nil {
+			return err
+		}
+		c.packet(p, Outbound)
+	}
 	return
 }
 
-func (c *pollingConn) ID() string {
-	return c.sid
+func (c *pollingConn) name() string {
+	return TransportPolling
 }
 
-func (c *pollingConn) Write(data []byte) (int, error) {
+func (c *pollingConn) write(p parser.Packet) (int, error) {
 	c.rwmu.Lock()
 	defer c.rwmu.Unlock()
 
-	if !c.connected {
+	if !c.connected || c.closing || c.upgraded {
 		return 0, ErrNotConnected
 	}
 
 	select {
-	case c.queue <- packet{
-		index: c.index,
-		Type:  messageID,
-		Data:  data,
-	}:
+	case c.queue <- p:
 
 	default:
 		return 0, ErrQueueFull
 	}
-	return len(data), nil
+	return len(p.Data), nil
 }
 
 func (c *pollingConn) Close() error {
-	c.rwmu.Lock()
-	defer c.rwmu.Unlock()
+	return c.close(ForcedClose, nil)
+}
 
+// close closes the connection with the close reason of code caused by
+// err, which is passed to the close callback.
+func (c *pollingConn) close(code CloseCode, err error) error {
+	c.rwmu.Lock()
+	if !c.connected {
+		c.rwmu.Unlock()
+		return nil
+	}
 	c.connected = false
+	c.heartbeat.stop()
 	close(c.queue)
+	close(c.closed)
+	c.rwmu.Unlock()
 
-	if !c.upgraded {
+	if c.closeFn != nil {
+		c.closeFn(&CloseReason{Code: code, Err: err})
+	}
+	return nil
+}
+
+// shutdown queues a close packet, once the queue is flushed or ctx is
+// done the connection gets closed.
+func (c *pollingConn) shutdown(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	// retry while the queue is full
+	for queued := false; !queued; {
+		c.rwmu.Lock()
+		if !c.connected {
+			c.rwmu.Unlock()
+			return nil
+		}
+		c.closing = true
 		select {
-		case c.remove <- c.sid:
+		case c.queue <- parser.Packet{Type: parser.Close}:
+			queued = true
+
 		default:
 		}
+		c.rwmu.Unlock()
 
-		if c.closeFn != nil {
-			c.closeFn(c)
+		if !queued {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return c.close(ServerShutdown, nil)
+			}
 		}
 	}
 
-	return nil
+	select {
+	case <-c.drained:
+	case <-ctx.Done():
+	}
+	return c.close(ServerShutdown, nil)
 }
 
-func (c *pollingConn) upgrade(p packet) error {
+// upgrade queues p as the last packet of the connection, following
+// writes fail.
+func (c *pollingConn) upgrade(p parser.Packet) error {
 	c.rwmu.Lock()
-	defer func() {
-		c.upgraded = true
-		c.rwmu.Unlock()
-	}()
+	defer c.rwmu.Unlock()
 
 	if !c.connected {
 		return ErrNotConnected
 	}
 
-	p.index = c.index
 	select {
 	case c.queue <- p:
 
 	default:
 		return ErrQueueFull
 	}
+	c.upgraded = true
 	return nil
 }
 
-func (c *pollingConn) encode(p packet) []byte {
-	data := append([]byte(p.Type), p.Data...)
-	n := utf8.RuneCount(data)
+// abortUpgrade resumes the connection after a failed upgrade.
+func (c *pollingConn) abortUpgrade() {
+	c.rwmu.Lock()
+	c.upgraded = false
+	c.rwmu.Unlock()
+}
+
+func (c *pollingConn) encode(p parser.Packet) []byte {
+	data, _ := c.encodePayload([]parser.Packet{p})
+	return data
+}
 
+// encodePayload encodes packets into a single payload, wrapped into the
+// jsonp callback if jsonp is used. encodePayload returns the payload and
+// its content type, which is empty for jsonp.
+func (c *pollingConn) encodePayload(packets []parser.Packet) ([]byte, string) {
 	if c.index != -1 {
-		ndata := fmt.Sprintf("%d:", n)
-		data = append([]byte(ndata), data...)
-		ndata = fmt.Sprintf("___eio[%d](%q);", c.index, data)
-		return []byte(ndata)
+		data, _ := parser.EncodePayload(packets, c.protocol, true)
+		return []byte(fmt.Sprintf("___eio[%d](%q);", c.index, data)), ""
+	}
+
+	data, binary := parser.EncodePayload(packets, c.protocol, c.b64)
+	if binary {
+		return data, "application/octet-stream"
 	}
+	return data, "text/plain; charset=UTF-8"
+}
 
-	ndata := fmt.Sprintf("%d:", n)
-	return append([]byte(ndata), data...)
+// ping queues a ping packet.
+func (c *pollingConn) ping() error {
+	return c.heartbeatPacket(parser.Packet{Type: parser.Ping})
+}
+
+// heartbeatPacket queues the ping or pong packet p. If the queue is
+// full, the client doesn't keep up polling and the connection gets
+// closed.
+func (c *pollingConn) heartbeatPacket(p parser.Packet) error {
+	c.rwmu.Lock()
+	if !c.connected || c.upgraded || c.closing {
+		c.rwmu.Unlock()
+		return ErrNotConnected
+	}
+	select {
+	case c.queue <- p:
+		c.rwmu.Unlock()
+		return nil
+
+	default:
+		c.rwmu.Unlock()
+	}
+
+	return c.close(QueueOverflow, ErrQueueFull)
 }
 
 func (c *pollingConn) flusher() {
-	buf := bytes.NewBuffer(nil)
-	heartbeats := 0
+	packets := make([]parser.Packet, 0, c.queueLength)
 
 	for p := range c.queue {
-		num := int64(-1)
+		packets = append(packets, p)
 
-		switch p.Type {
-		case heartbeatID:
-			time.Sleep(500 * time.Millisecond)
-			heartbeats++
-		case pongID:
-			num = p.connNum
-			buf.Write(c.encode(p))
-		default:
-			buf.Write(c.encode(p))
+		// wait for a writer before draining the queue, packets queued
+		// in the meantime are sent along
+		writer := c.writer()
+		if writer == nil {
+			return
 		}
-		n := 1
 
 	DrainLoop:
-		for n < c.queueLength {
+		for len(packets) < c.queueLength {
 			select {
-			case p = <-c.queue:
-				n++
-				switch p.Type {
-				case heartbeatID:
-					time.Sleep(500 * time.Millisecond)
-					heartbeats++
-				case pongID:
-					num = p.connNum
-					buf.Write(c.encode(p))
-				default:
-					buf.Write(c.encode(p))
+			case q, ok := <-c.queue:
+				if !ok {
+					break DrainLoop
 				}
+				packets = append(packets, q)
 
 			default:
 				break DrainLoop
 			}
 		}
 
-		c.mu.RLock()
+		if _, err := writer.write(c.encodePayload(packets)); err != nil {
+			c.close(TransportError, err)
+			return
+		}
+		for _, p := range packets {
+			c.packet(p, Outbound)
+		}
+		c.flushed(packets)
+		if c.drainFn != nil {
+			c.drainFn()
+		}
+		packets = packets[:0]
+	}
+}
+
+// writer takes the first polling writer, waiting for one to be added.
+// It returns nil once the connection is closed.
+func (c *pollingConn) writer() *pollingWriter {
+	for {
+		c.mu.Lock()
 		var writer *pollingWriter
-		if num != -1 {
-			writer = c.connections[num]
-		} else { // get first writer
-			for _, writer = range c.connections {
-				break
-			}
+		for num, w := range c.connections {
+			writer = w
+			delete(c.connections, num)
+			break
 		}
-		c.mu.RUnlock()
+		added := c.writers
+		c.mu.Unlock()
 
 		if writer != nil {
-			if _, err := writer.Write(buf.Bytes()); err != nil {
-				c.Close()
-				return
-			}
-			buf.Reset()
-			heartbeats = 0
-		} else {
-			if heartbeats >= maxHeartbeat-1 {
-				c.Close()
-				return
-			}
+			return writer
+		}
 
-			c.rwmu.Lock()
-			if !c.connected {
-				c.rwmu.Unlock()
-				return
-			}
-			select {
-			case c.queue <- packet{index: c.index, Type: heartbeatID}:
+		select {
+		case <-added:
+		case <-c.closed:
+			return nil
+		}
+	}
+}
 
-			default:
-			}
-			c.rwmu.Unlock()
+// flushed signals drained if packets contain the close packet.
+func (c *pollingConn) flushed(packets []parser.Packet) {
+	for _, p := range packets {
+		if p.Type != parser.Close {
+			continue
+		}
+
+		select {
+		case <-c.drained:
+		default:
+			close(c.drained)
 		}
+		return
 	}
 }
 
-func (c *pollingConn) messageFunc(fn func(Connection, []byte) error) {
+func (c *pollingConn) messageFunc(fn func(parser.Packet) error) {
 	c.messageFn = fn
 }
 
-func (c *pollingConn) closeFunc(fn func(Connection)) {
+func (c *pollingConn) closeFunc(fn func(*CloseReason)) {
 	c.closeFn = fn
 }
+
+func (c *pollingConn) packetFunc(fn func(parser.Packet, Direction)) {
+	c.packetFn = fn
+}
+
+func (c *pollingConn) drainFunc(fn func()) {
+	c.drainFn = fn
+}
+
+// packet invokes the packet callback.
+func (c *pollingConn) packet(p parser.Packet, d Direction) {
+	if c.packetFn != nil {
+		c.packetFn(p, d)
+	}
+}
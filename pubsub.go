@@ -0,0 +1,134 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// PubSub is a publish/subscribe system connecting the nodes of a cluster,
+// e.g. backed by Redis or NATS. Implementations must be safe for
+// concurrent use.
+type PubSub interface {
+	// Publish publishes data to channel.
+	Publish(channel string, data []byte) error
+
+	// Subscribe calls fn for each message published to channel.
+	Subscribe(channel string, fn func(data []byte)) error
+}
+
+// pubSubMessage is a broadcast message along with its origin node.
+type pubSubMessage struct {
+	Node    string   `json:"node"`
+	Message *Message `json:"message"`
+}
+
+// pubSubAdapter delivers broadcasts to the local sessions and publishes
+// them to the other nodes. Room memberships are kept locally, each node
+// delivers a broadcast to the members of its own sessions.
+type pubSubAdapter struct {
+	*memoryAdapter
+
+	mu      sync.RWMutex // protects bound
+	bound   bool         // indicates if the adapter has been initialized
+	pubsub  PubSub
+	channel string
+	node    string
+}
+
+// NewPubSubAdapter returns an Adapter broadcasting across all nodes
+// subscribed to channel of ps. Rooms span the cluster, a broadcast to a
+// room reaches its members on every node.
+func NewPubSubAdapter(ps PubSub, channel string) (Adapter, error) {
+	a := &pubSubAdapter{
+		memoryAdapter: &memoryAdapter{rooms: newRooms()},
+		pubsub:        ps,
+		channel:       channel,
+		node:          newSessionId(),
+	}
+
+	if err := ps.Subscribe(channel, a.receive); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *pubSubAdapter) Init(sessions SessionStore) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.memoryAdapter.Init(sessions)
+	a.bound = true
+}
+
+// Broadcast delivers m to the local sessions and publishes it to the
+// other nodes. If publishing fails, its error is returned, otherwise the
+// errors of the local writes.
+func (a *pubSubAdapter) Broadcast(m *Message) error {
+	err := a.memoryAdapter.Broadcast(m)
+
+	data, jerr := json.Marshal(pubSubMessage{Node: a.node, Message: m})
+	if jerr != nil {
+		return jerr
+	}
+	if perr := a.pubsub.Publish(a.channel, data); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// receive delivers messages published by other nodes to the local
+// sessions.
+func (a *pubSubAdapter) receive(data []byte) {
+	var msg pubSubMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Message == nil {
+		return
+	}
+	if msg.Node == a.node {
+		return
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.bound {
+		a.memoryAdapter.Broadcast(msg.Message)
+	}
+}
+
+// MemoryBroker is an in-memory PubSub, connecting the adapters of a single
+// process. Subscribers are called synchronously by Publish.
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string][]func(data []byte)
+}
+
+// NewMemoryBroker returns a new MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: make(map[string][]func(data []byte)),
+	}
+}
+
+func (b *MemoryBroker) Publish(channel string, data []byte) error {
+	b.mu.RLock()
+	subscribers := append([]func(data []byte){}, b.subscribers[channel]...)
+	b.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(data)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(channel string, fn func(data []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[channel] = append(b.subscribers[channel], fn)
+	return nil
+}
@@ -0,0 +1,82 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+func TestPubSubAdapter(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	var (
+		nodes   []*EngineIO
+		clients []*websocket.Conn
+		members []Connection
+		data    string
+	)
+	for i := 0; i < 2; i++ {
+		adapter, err := NewPubSubAdapter(broker, "engineio")
+		if err != nil {
+			t.Fatalf("adapter: %v", err)
+		}
+		config := *DefaultConfig
+		config.Adapter = adapter
+
+		conns := make(chan Connection, 1)
+		e := NewEngineIO(&config)
+		e.ConnectionFunc(func(conn Connection) {
+			conns <- conn
+		})
+
+		server := httptest.NewServer(e)
+		defer server.Close()
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+		ws, err := websocket.Dial(url, "", server.URL)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer ws.Close()
+
+		if err = websocket.Message.Receive(ws, &data); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+
+		conn := <-conns
+		e.Join(conn, "room")
+
+		nodes = append(nodes, e)
+		clients = append(clients, ws)
+		members = append(members, conn)
+	}
+
+	if err := nodes[0].To("room").Write([]byte("room")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := nodes[1].Except(members[0]).Write([]byte("except")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := nodes[1].Broadcast([]byte("all")); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+
+	expected := [][]string{{"4room", "4all"}, {"4room", "4except", "4all"}}
+	for i, ws := range clients {
+		for _, msg := range expected[i] {
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				t.Fatalf("receive: %v", err)
+			}
+			if data != msg {
+				t.Fatalf("client %d: expect %q, got %q", i, msg, data)
+			}
+		}
+	}
+}
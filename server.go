@@ -6,39 +6,53 @@
 package engineio
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/massiveart/engineio/internal/inbox"
+	"github.com/massiveart/engineio/parser"
 )
 
 var (
-	ErrUnknownSession = errors.New("unknown session id")
-	ErrQueueFull      = errors.New("queue limit reached")
-	ErrNotConnected   = errors.New("not connected")
+	ErrUnknownSession  = errors.New("unknown session id")
+	ErrQueueFull       = errors.New("queue limit reached")
+	ErrNotConnected    = errors.New("not connected")
+	ErrServerClosed    = errors.New("server closed")
+	ErrPayloadTooLarge = errors.New("payload too large")
 )
 
 // EngineIO handles transport abstraction and provide the user a handfull
 // of callbacks to observe different events.
 type EngineIO struct {
-	sessions map[string]Connection
-	remove   chan string
+	sessions SessionStore
+	adapter  Adapter
 	config   *Config
 
-	connectionFunc func(Connection)
-	messageFunc    func(Connection, []byte) error
-	closeFunc      func(Connection)
+	mu      sync.Mutex // protects closing
+	closing bool       // indicates if handshakes are rejected
+
+	handshakeFunc     func(*http.Request, http.Header)
+	connectionFunc    func(Connection)
+	messageFunc       func(Connection, []byte) error
+	binaryMessageFunc func(Connection, []byte) error
+	closeFunc         func(Connection, *CloseReason)
+	upgradeFunc       func(Connection)
+	upgradeErrorFunc  func(Connection, error)
+	packetFunc        func(Connection, parser.Packet, Direction)
+	drainFunc         func(Connection)
+	heartbeatFunc     func(Connection)
+	errorFunc         func(*http.Request, *Error)
 }
 
 // NewEngineIO allocates and returns a new EngineIO. If config is nil,
 // the DefaultConfig is used.
 func NewEngineIO(config *Config) *EngineIO {
-	e := &EngineIO{
-		sessions: make(map[string]Connection),
-		remove:   make(chan string),
-	}
+	e := &EngineIO{}
 
 	if config == nil {
 		e.config = DefaultConfig
@@ -46,76 +60,292 @@ func NewEngineIO(config *Config) *EngineIO {
 		e.config = config
 	}
 
-	go e.remover()
+	if e.config.SessionStore != nil {
+		e.sessions = e.config.SessionStore
+	} else {
+		e.sessions = NewMemoryStore()
+	}
+
+	if e.config.Adapter != nil {
+		e.adapter = e.config.Adapter
+	} else {
+		e.adapter = NewMemoryAdapter()
+	}
+	e.adapter.Init(e.sessions)
 	return e
 }
 
-// Close closes the engineio server and all it's connections.
-func (e EngineIO) Close() error {
-	for _, conn := range e.sessions {
-		conn.Close()
-	}
+// Close closes the engineio server and all it's connections immediately.
+// Undelivered messages are dropped, see Shutdown.
+func (e *EngineIO) Close() error {
+	e.mu.Lock()
+	e.closing = true
+	e.mu.Unlock()
 
-	close(e.remove)
+	e.sessions.Range(func(sid string, conn Connection) bool {
+		conn.Close()
+		return true
+	})
 	return nil
 }
 
-func (e EngineIO) remover() {
-	for {
-		sid := <-e.remove
-		delete(e.sessions, sid)
+// Shutdown gracefully shuts down the engineio server. New handshakes are
+// rejected and a close packet is sent to every session; each connection
+// is closed once its pending messages are delivered. If ctx is done
+// before, the remaining connections are closed immediately and the
+// context's error is returned.
+func (e *EngineIO) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	e.closing = true
+	e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	e.sessions.Range(func(sid string, conn Connection) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn.shutdown(ctx)
+		}()
+		return true
+	})
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// accepting reports if handshakes are accepted.
+func (e *EngineIO) accepting() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.closing
+}
+
+// register stores the new session s. If a shutdown began since the
+// handshake was accepted, the shutdown might have missed s; it is closed
+// and false is returned.
+func (e *EngineIO) register(s *session) bool {
+	e.sessions.Put(s.ID(), s)
+	if !e.accepting() {
+		s.close(ServerShutdown, nil)
+		return false
 	}
+	return true
 }
 
-// handshake returns a polling connection and an error if any.
-// TODO: implement websocket handshake
-func (e *EngineIO) handshake(w io.Writer, sid string, index int) (Connection, error) {
+// openPacket returns the open packet data of session sid. upgrades
+// indicates if the available upgrades are advertised.
+func (e *EngineIO) openPacket(sid string, protocol int, upgrades bool) ([]byte, error) {
 	var payload = struct {
 		Sid          string   `json:"sid"`
 		Upgrades     []string `json:"upgrades"`
 		PingInterval int64    `json:"pingInterval"`
 		PingTimeout  int64    `json:"pingTimeout"`
+		MaxPayload   int64    `json:"maxPayload,omitempty"`
 	}{
 		Sid:          sid,
 		PingInterval: e.config.PingInterval,
 		PingTimeout:  e.config.PingTimeout,
-		Upgrades:     e.config.Upgrades,
+		Upgrades:     []string{},
 	}
-	data, err := json.Marshal(payload)
+	if upgrades {
+		for _, upgrade := range e.config.Upgrades {
+			if e.config.allowed(upgrade) {
+				payload.Upgrades = append(payload.Upgrades, upgrade)
+			}
+		}
+	}
+	if protocol >= parser.Protocol4 {
+		payload.MaxPayload = e.config.maxPayload()
+	}
+	return json.Marshal(payload)
+}
+
+// handshake returns a polling connection and an error if any. The open
+// packet is sent along with the initial packet.
+func (e *EngineIO) handshake(w http.ResponseWriter, req *http.Request, sid string, index, protocol int, b64 bool, v *values) (*session, error) {
+	data, err := e.openPacket(sid, protocol, true)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO
 	conn := &pollingConn{
-		queue:        make(chan packet, e.config.QueueLength+maxHeartbeat),
-		connections:  make(map[int64]*pollingWriter),
-		connected:    true,
-		index:        index,
-		sid:          sid,
-		remove:       e.remove,
-		pingInterval: time.Duration(e.config.PingInterval),
-		queueLength:  e.config.QueueLength + maxHeartbeat,
+		queue:           make(chan parser.Packet, e.config.QueueLength+maxHeartbeat),
+		connections:     make(map[int64]*pollingWriter),
+		writers:         make(chan struct{}),
+		drained:         make(chan struct{}),
+		closed:          make(chan struct{}),
+		connected:       true,
+		index:           index,
+		protocol:        protocol,
+		b64:             b64,
+		maxPayload:      e.config.maxPayload(),
+		pollingDuration: time.Duration(e.config.pollingDuration()),
+		queueLength:     e.config.QueueLength + maxHeartbeat,
 	}
 
+	s := e.newSession(sid, req, v, conn)
+	conn.heartbeat = newHeartbeat(
+		time.Duration(e.config.PingInterval),
+		time.Duration(e.config.PingTimeout),
+		protocol,
+		conn.ping,
+		func() { conn.close(PingTimeout, nil) },
+	)
+
 	// polling queue flusher
 	go conn.flusher()
 
-	_, err = w.Write(conn.encode(packet{
-		index: index,
-		Type:  openID,
-		Data:  data,
-	}))
-	if err != nil {
+	if e.handshakeFunc != nil {
+		e.handshakeFunc(req, w.Header())
+	}
+
+	packets := []parser.Packet{{Type: parser.Open, Data: data}}
+	if e.config.InitialPacket != nil {
+		packets = append(packets, parser.Packet{Type: parser.Message, Data: e.config.InitialPacket})
+	}
+	payload, _ := conn.encodePayload(packets)
+	if _, err = w.Write(payload); err != nil {
+		// the session is never established, the transport is closed
+		// without invoking the callbacks
+		conn.closeFunc(nil)
+		conn.close(TransportError, err)
 		return nil, err
 	}
+	for _, p := range packets {
+		conn.packet(p, Outbound)
+	}
 
-	return conn, nil
+	return s, nil
 }
 
-type AuthFunc func(*http.Request) bool
+// websocketHandshake establishes a websocket connection without a
+// preceding polling connection. It blocks until the connection is
+// closed.
+func (e *EngineIO) websocketHandshake(w http.ResponseWriter, req *http.Request, sid string, protocol int, v *values) {
+	data, err := e.openPacket(sid, protocol, false)
+	if err != nil {
+		rerr := newError(BadRequest, err)
+		rerr.Status = http.StatusInternalServerError
+		e.requestError(w, req, rerr)
+		return
+	}
+
+	conn := &websocketConn{
+		open:          data,
+		initial:       e.config.InitialPacket,
+		protocol:      protocol,
+		maxPayload:    e.config.maxPayload(),
+		pingInterval:  time.Duration(e.config.PingInterval),
+		pingTimeout:   time.Duration(e.config.PingTimeout),
+		originChecked: e.config.AllowOrigin != nil,
+	}
+	s := e.newSession(sid, req, v, conn)
+
+	if e.handshakeFunc != nil {
+		e.handshakeFunc(req, w.Header())
+	}
+
+	if err := conn.accept(w, req); err != nil {
+		// we can't send any error message on a hijack'd connection.
+		return
+	}
+
+	if !e.register(s) {
+		return
+	}
+	if e.connectionFunc != nil {
+		e.connectionFunc(s)
+	}
+	conn.reader()
+}
+
+// newSession returns a new session connected with transport t.
+func (e *EngineIO) newSession(sid string, req *http.Request, v *values, t transport) *session {
+	s := &session{
+		values:      v,
+		metadata:    newMetadata(req),
+		Inbox:       inbox.New(e.config.QueueLength),
+		sid:         sid,
+		queueLength: e.config.QueueLength,
+		remove:      e.sessions.Delete,
+		closeFn:     e.onClose,
+		conn:        t,
+	}
+	e.attach(s, t)
+	return s
+}
+
+// attach initializes the function callbacks of transport t of session s.
+func (e *EngineIO) attach(s *session, t transport) {
+	t.messageFunc(func(p parser.Packet) error {
+		return e.onMessage(s, p)
+	})
+	t.closeFunc(func(reason *CloseReason) {
+		s.transportClosed(t, reason)
+	})
+	t.packetFunc(func(p parser.Packet, d Direction) {
+		e.onPacket(s, p, d)
+	})
+	t.drainFunc(func() {
+		e.onDrain(s)
+	})
+}
+
+// AuthFunc authorizes the handshake request req. A non nil error rejects
+// the handshake with a Forbidden error; the response status code is
+// taken from a *StatusError and defaults to http.StatusForbidden. The
+// returned values are attached to the new session and can be retrieved
+// with Connection.Get.
+type AuthFunc func(req *http.Request) (map[string]interface{}, error)
+
+// OriginFunc reports if request req from origin is allowed. Requests
+// rejected get a Forbidden error.
+type OriginFunc func(origin string, req *http.Request) bool
+
+// StatusError is an error along with the http status code to respond
+// with.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// authorize authorizes the handshake request req with the configured
+// AuthFunc. If the request is rejected, an error response is written
+// and nil values along with false is returned.
+func (e *EngineIO) authorize(w http.ResponseWriter, req *http.Request) (*values, bool) {
+	if e.config.AuthFunc == nil {
+		return newValues(nil), true
+	}
+
+	v, err := e.config.AuthFunc(req)
+	if err != nil {
+		rerr := newError(Forbidden, err)
+		rerr.Message = err.Error()
+		if serr, ok := err.(*StatusError); ok {
+			rerr.Status = serr.Code
+		}
+		e.requestError(w, req, rerr)
+		return nil, false
+	}
+
+	return newValues(v), true
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (e *EngineIO) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if e.config.CORS != nil && e.config.CORS.handle(w, req) {
+		return
+	}
+
+	// the body is bounded before the form is parsed, jsonp payloads are
+	// posted as form values
+	req.Body = http.MaxBytesReader(w, req.Body, e.config.maxPayload())
+	_, tooLarge := req.ParseForm().(*http.MaxBytesError)
 
-func (e *EngineIO) Handler(w http.ResponseWriter, req *http.Request, fn AuthFunc) {
 	var err error
 	sid := req.FormValue("sid")
 	jindex := req.FormValue("j")
@@ -123,77 +353,175 @@ func (e *EngineIO) Handler(w http.ResponseWriter, req *http.Request, fn AuthFunc
 	if jindex != "" {
 		index, err = strconv.Atoi(jindex)
 		if err != nil {
-			http.Error(w, "atoi: "+err.Error(), http.StatusBadRequest)
+			e.requestError(w, req, newError(BadRequest, err))
+			return
+		}
+	}
+
+	// clients not sending the protocol revision speak protocol v3
+	protocol := parser.Protocol3
+	if eio := req.FormValue("EIO"); eio != "" {
+		protocol, err = strconv.Atoi(eio)
+		if err != nil || protocol < parser.Protocol2 || protocol > parser.Protocol4 {
+			e.requestError(w, req, newError(UnsupportedProtocolVersion, err))
+			return
+		}
+	}
+
+	upgrade := req.Header.Get("Upgrade") == "websocket"
+	transport := req.FormValue("transport")
+	if !e.config.allowed(transport) {
+		e.requestError(w, req, newError(UnknownTransport, errors.New("unknown transport "+strconv.Quote(transport))))
+		return
+	}
+	if upgrade != (transport == TransportWebsocket) {
+		e.requestError(w, req, newError(BadRequest, errors.New("transport mismatch "+strconv.Quote(transport))))
+		return
+	}
+	// the websocket server panics on a response writer it can't hijack
+	if _, ok := w.(http.Hijacker); upgrade && !ok {
+		e.requestError(w, req, newError(BadRequest, errors.New("websocket connection can't be hijacked")))
+		return
+	}
+
+	// websocket and JSONP requests aren't protected by the same-origin
+	// policy
+	if (upgrade || index != -1) && e.config.AllowOrigin != nil {
+		if origin := req.Header.Get("Origin"); !e.config.AllowOrigin(origin, req) {
+			e.requestError(w, req, newError(Forbidden, errors.New("origin not allowed "+strconv.Quote(origin))))
 			return
 		}
 	}
 
 	switch uint(len(sid)) {
 	case 0:
+		if !e.accepting() {
+			rerr := newError(BadRequest, ErrServerClosed)
+			rerr.Status = http.StatusServiceUnavailable
+			e.requestError(w, req, rerr)
+			return
+		}
+		if req.Method != "GET" {
+			e.requestError(w, req, newError(BadHandshakeMethod, nil))
+			return
+		}
 		sid = newSessionId()
 
-		if fn != nil {
-			if !fn(req) {
-				http.Error(w, "not authorized", http.StatusUnauthorized)
-				return
-			}
+		v, ok := e.authorize(w, req)
+		if !ok {
+			return
 		}
 
-		conn, err := e.handshake(w, sid, index)
+		if e.config.Cookie != nil {
+			http.SetCookie(w, e.config.Cookie.cookie(sid))
+		}
+
+		if upgrade {
+			e.websocketHandshake(w, req, sid, protocol, v)
+			return
+		}
+
+		conn, err := e.handshake(w, req, sid, index, protocol, req.FormValue("b64") != "", v)
 		if err != nil {
-			http.Error(w, "handshake: "+err.Error(), http.StatusInternalServerError)
+			rerr := newError(BadRequest, err)
+			rerr.Status = http.StatusInternalServerError
+			e.requestError(w, req, rerr)
 			return
 		}
 
-		// initialize function callbacks
+		if !e.register(conn) {
+			return
+		}
 		if e.connectionFunc != nil {
 			e.connectionFunc(conn)
 		}
-		conn.messageFunc(e.messageFunc)
-		conn.closeFunc(e.closeFunc)
-		e.sessions[sid] = conn
 
 	default:
-		conn, found := e.sessions[sid]
+		conn, found := e.sessions.Get(sid)
 		if !found {
-			http.Error(w, ErrUnknownSession.Error(), http.StatusBadRequest)
+			e.requestError(w, req, newError(UnknownSid, ErrUnknownSession))
 			return
 		}
 
-		if upgrade := req.Header.Get("Upgrade"); upgrade == "websocket" {
-			prevConn := conn
+		if upgrade {
+			s := conn.(*session)
+			prevConn := s.transport()
+			if prevConn.name() == TransportWebsocket {
+				e.requestError(w, req, newError(BadRequest, errors.New("session upgraded already")))
+				return
+			}
+
+			// writes are buffered until the upgrade ends
+			if !s.beginUpgrade() {
+				e.requestError(w, req, newError(BadRequest, errors.New("upgrade in progress")))
+				return
+			}
+
 			newConn := &websocketConn{
-				prevConn:    prevConn,
-				sid:         sid,
-				remove:      e.remove,
-				pingTimeout: time.Duration(e.config.PingTimeout),
+				prevConn:       prevConn,
+				protocol:       protocol,
+				maxPayload:     e.config.maxPayload(),
+				pingInterval:   time.Duration(e.config.PingInterval),
+				pingTimeout:    time.Duration(e.config.PingTimeout),
+				upgradeTimeout: time.Duration(e.config.upgradeTimeout()),
+				originChecked:  e.config.AllowOrigin != nil,
 			}
-			// initialize function callbacks
-			newConn.closeFunc(e.closeFunc)
-			newConn.messageFunc(e.messageFunc)
-
-			if err := newConn.handle(w, req); err != nil {
-				// got i/o timeout, remove session; we can't send
-				// andy error message on a hijack'd connection.
-				select {
-				case e.remove <- sid:
-				default:
+			e.attach(s, newConn)
+
+			if err := newConn.accept(w, req); err != nil {
+				// we can't send any error message on a hijack'd
+				// connection; the session goes on with the previous
+				// transport.
+				prevConn.abortUpgrade()
+				s.endUpgrade(prevConn)
+				if e.upgradeErrorFunc != nil {
+					e.upgradeErrorFunc(s, err)
 				}
 				return
 			}
 
+			if !s.endUpgrade(newConn) {
+				return
+			}
+			prevConn.Close()
+			if e.upgradeFunc != nil {
+				e.upgradeFunc(s)
+			}
+			newConn.reader()
 			return
 		}
 
 		// polling connection
-		conn.(*pollingConn).index = index
+		if tooLarge {
+			conn.(*session).close(PayloadTooLarge, nil)
+			rerr := newError(BadRequest, ErrPayloadTooLarge)
+			rerr.Status = http.StatusRequestEntityTooLarge
+			e.requestError(w, req, rerr)
+			return
+		}
+		if transport != conn.Transport() {
+			e.requestError(w, req, newError(BadRequest, errors.New("transport mismatch "+strconv.Quote(transport))))
+			return
+		}
 		if err := conn.handle(w, req); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			rerr := newError(BadRequest, err)
+			if err == ErrPayloadTooLarge {
+				rerr.Status = http.StatusRequestEntityTooLarge
+			}
+			e.requestError(w, req, rerr)
 			return
 		}
 	}
 }
 
+// HandshakeFunc sets fn to be invoked when a handshake is accepted,
+// before the open packet is sent. It passes the handshake request along
+// with the response header, which may be modified, as arguments to the
+// callback.
+func (e *EngineIO) HandshakeFunc(fn func(*http.Request, http.Header)) {
+	e.handshakeFunc = fn
+}
+
 // ConnectionFunc sets fn to be invoked when a new connection is
 // established. It passes the established connection as an argument to
 // the callback.
@@ -203,15 +531,130 @@ func (e *EngineIO) ConnectionFunc(fn func(Connection)) {
 
 // MessageFunc sets fn to be invoked when a message arrives. It passes
 // the established connection along with the received message datai as
-// arguments to the callback.
+// arguments to the callback. If no message callback is set, messages
+// are buffered for Connection.ReadMessage.
 func (e *EngineIO) MessageFunc(fn func(Connection, []byte) error) {
 	e.messageFunc = fn
 }
 
+// BinaryMessageFunc sets fn to be invoked when a binary message arrives.
+// It passes the established connection along with the received message
+// data as arguments to the callback. If no binary message callback is
+// set, binary messages are passed to the MessageFunc callback.
+func (e *EngineIO) BinaryMessageFunc(fn func(Connection, []byte) error) {
+	e.binaryMessageFunc = fn
+}
+
+// onMessage invokes the message callback responsible for p.
+func (e *EngineIO) onMessage(conn Connection, p parser.Packet) error {
+	fn := e.messageFunc
+	if p.Binary && e.binaryMessageFunc != nil {
+		fn = e.binaryMessageFunc
+	}
+
+	if fn == nil {
+		return conn.push(p.Data)
+	}
+	return fn(conn, p.Data)
+}
+
 // CloseFunc sets fn to be invoked when a session is considered to be
-// lost. It passes the established connection as an argument to the
-// callback. After disconnection the connection is considered to be
-// destroyed, and it should not be used anymore.
-func (e *EngineIO) CloseFunc(fn func(Connection)) {
+// lost. It passes the established connection along with the close
+// reason as arguments to the callback. After disconnection the
+// connection is considered to be destroyed, and it should not be used
+// anymore.
+func (e *EngineIO) CloseFunc(fn func(Connection, *CloseReason)) {
 	e.closeFunc = fn
 }
+
+// onClose releases the room memberships of conn and invokes the close
+// callback.
+func (e *EngineIO) onClose(conn Connection, reason *CloseReason) {
+	e.adapter.LeaveAll(conn.ID())
+
+	if e.closeFunc != nil {
+		e.closeFunc(conn, reason)
+	}
+}
+
+// UpgradeFunc sets fn to be invoked when a session is upgraded to
+// websocket. It passes the connection as an argument to the callback.
+// Writes issued during the upgrade are buffered and sent over websocket
+// afterwards.
+func (e *EngineIO) UpgradeFunc(fn func(Connection)) {
+	e.upgradeFunc = fn
+}
+
+// UpgradeErrorFunc sets fn to be invoked when the upgrade of a session
+// to websocket fails. It passes the previous connection along with the
+// error as arguments to the callback.
+func (e *EngineIO) UpgradeErrorFunc(fn func(Connection, error)) {
+	e.upgradeErrorFunc = fn
+}
+
+// PacketFunc sets fn to be invoked for every packet received from or
+// sent to a client, including pings and pongs. It passes the connection
+// along with the packet and its direction as arguments to the callback.
+// The callback may be invoked concurrently.
+func (e *EngineIO) PacketFunc(fn func(Connection, parser.Packet, Direction)) {
+	e.packetFunc = fn
+}
+
+// DrainFunc sets fn to be invoked when the outbound packets of a
+// connection are written to the client. It passes the connection as an
+// argument to the callback.
+func (e *EngineIO) DrainFunc(fn func(Connection)) {
+	e.drainFunc = fn
+}
+
+// HeartbeatFunc sets fn to be invoked when a heartbeat arrives, that is
+// a pong from a protocol v4 client or a ping from an older client. The
+// probe ping of an upgrade is a heartbeat as well. It passes the
+// connection as an argument to the callback.
+func (e *EngineIO) HeartbeatFunc(fn func(Connection)) {
+	e.heartbeatFunc = fn
+}
+
+// onPacket invokes the packet and heartbeat callbacks.
+func (e *EngineIO) onPacket(conn Connection, p parser.Packet, d Direction) {
+	if e.packetFunc != nil {
+		e.packetFunc(conn, p, d)
+	}
+
+	if d == Inbound && (p.Type == parser.Ping || p.Type == parser.Pong) && e.heartbeatFunc != nil {
+		e.heartbeatFunc(conn)
+	}
+}
+
+// onDrain invokes the drain callback.
+func (e *EngineIO) onDrain(conn Connection) {
+	if e.drainFunc != nil {
+		e.drainFunc(conn)
+	}
+}
+
+// Join adds conn to room.
+func (e *EngineIO) Join(conn Connection, room string) {
+	e.adapter.Join(conn.ID(), room)
+}
+
+// Leave removes conn from room.
+func (e *EngineIO) Leave(conn Connection, room string) {
+	e.adapter.Leave(conn.ID(), room)
+}
+
+// Broadcast writes data as message to all sessions. The returned error
+// is a BroadcastError if any write fails.
+func (e *EngineIO) Broadcast(data []byte) error {
+	return e.Except().Write(data)
+}
+
+// To returns a Broadcaster targeting the members of room.
+func (e *EngineIO) To(room string) *Broadcaster {
+	return (&Broadcaster{e: e}).To(room)
+}
+
+// Except returns a Broadcaster targeting all sessions but conns.
+func (e *EngineIO) Except(conns ...Connection) *Broadcaster {
+	return (&Broadcaster{e: e}).Except(conns...)
+}
@@ -0,0 +1,1363 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/massiveart/engineio/parser"
+)
+
+func TestWebsocketHandshake(t *testing.T) {
+	e := NewEngineIO(nil)
+	e.MessageFunc(func(conn Connection, data []byte) error {
+		_, err := conn.Write(data)
+		return err
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !strings.HasPrefix(data, `0{"sid":"`) || !strings.Contains(data, `"upgrades":[]`) {
+		t.Fatalf("open: expect open packet without upgrades, got %q", data)
+	}
+
+	if err = websocket.Message.Send(ws, "4hello"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if data != "4hello" {
+		t.Fatalf("receive: expect \"4hello\", got %q", data)
+	}
+}
+
+func TestAuthFunc(t *testing.T) {
+	e := NewEngineIO(&Config{
+		QueueLength:  10,
+		PingInterval: 25000,
+		PingTimeout:  60000,
+		AuthFunc: func(req *http.Request) (map[string]interface{}, error) {
+			if req.FormValue("token") != "secret" {
+				return nil, &StatusError{http.StatusForbidden, errors.New("forbidden")}
+			}
+			return map[string]interface{}{"user": "john"}, nil
+		},
+	})
+
+	user := make(chan interface{}, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		user <- conn.Get("user")
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("auth: expect status %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling&token=secret")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(string(data), "0{") {
+		t.Fatalf("auth: expect open packet, got %d %q", res.StatusCode, data)
+	}
+	if u := <-user; u != "john" {
+		t.Fatalf("auth: expect session value \"john\", got %v", u)
+	}
+}
+
+func TestConnectionFuncClose(t *testing.T) {
+	e := NewEngineIO(nil)
+	e.ConnectionFunc(func(conn Connection) {
+		conn.Close()
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	res.Body.Close()
+	if n := e.sessions.Len(); n != 0 {
+		t.Fatalf("close: expect no sessions, got %d", n)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errs := make(chan ErrorCode, 1)
+	e := NewEngineIO(nil)
+	e.ErrorFunc(func(req *http.Request, err *Error) {
+		errs <- err.Code
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		query  string
+		code   ErrorCode
+	}{
+		{"GET", "EIO=4", UnknownTransport},
+		{"GET", "EIO=4&transport=flash", UnknownTransport},
+		{"GET", "EIO=4&transport=polling&sid=unknown", UnknownSid},
+		{"POST", "EIO=4&transport=polling", BadHandshakeMethod},
+		{"GET", "EIO=4&transport=websocket", BadRequest},
+		{"GET", "EIO=4&transport=polling&j=x", BadRequest},
+		{"GET", "EIO=5&transport=polling", UnsupportedProtocolVersion},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+DefaultEngineioPath+"?"+test.query, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+
+		var body struct {
+			Code    ErrorCode `json:"code"`
+			Message string    `json:"message"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: decode: %v", test.query, err)
+		}
+
+		if res.StatusCode != http.StatusBadRequest || body.Code != test.code || body.Message != errorMessages[test.code] {
+			t.Fatalf("%s: expect %d %d, got %d %+v", test.query, http.StatusBadRequest, test.code, res.StatusCode, body)
+		}
+		if code := <-errs; code != test.code {
+			t.Fatalf("%s: expect error func code %d, got %d", test.query, test.code, code)
+		}
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	e := NewEngineIO(nil)
+	e.ConnectionFunc(func(conn Connection) {
+		go func() {
+			for {
+				data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.Write(data)
+			}
+		}()
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	for _, msg := range []string{"4one", "4two"} {
+		if err = websocket.Message.Send(ws, msg); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		if err = websocket.Message.Receive(ws, &data); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if data != msg {
+			t.Fatalf("receive: expect %q, got %q", msg, data)
+		}
+	}
+}
+
+func TestUnreadMessages(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=3&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	conn := <-conns
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	// messages exceeding the queue length are dropped, pings are still
+	// answered
+	for i := 0; i < 2*DefaultConfig.QueueLength; i++ {
+		if err = websocket.Message.Send(ws, fmt.Sprintf("4%d", i)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	if err = websocket.Message.Send(ws, "2"); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "3" {
+		t.Fatalf("pong: expect pong packet, got %q (%v)", data, err)
+	}
+
+	msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "0" {
+		t.Fatalf("read: expect first message, got %q (%v)", msg, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+
+	conn := <-conns
+	if _, err = conn.Write([]byte("bye")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- e.Shutdown(context.Background())
+	}()
+
+	// wait for the close packet to be queued
+	pc := conn.(*session).transport().(*pollingConn)
+	for i := 0; ; i++ {
+		pc.rwmu.Lock()
+		closing := pc.closing
+		pc.rwmu.Unlock()
+		if closing {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("shutdown: expect close packet to be queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res, err = http.Get(url + "&sid=" + conn.ID())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "4bye\x1e1" {
+		t.Fatalf("poll: expect message and close packet, got %q", data)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if _, found := e.sessions.Get(conn.ID()); found {
+		t.Fatalf("shutdown: expect session to be removed")
+	}
+
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable || body.Code != BadRequest {
+		t.Fatalf("handshake: expect %d %d, got %d %d (%v)", http.StatusServiceUnavailable, BadRequest, res.StatusCode, body.Code, err)
+	}
+}
+
+// failingWriter fails to write the response body.
+type failingWriter struct {
+	http.ResponseWriter
+}
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestHandshakeWriteError(t *testing.T) {
+	config := *DefaultConfig
+	config.PingInterval = 10
+	config.PingTimeout = 10
+	e := NewEngineIO(&config)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		t.Errorf("close: expect no close callback, got %q", reason)
+	})
+
+	req := httptest.NewRequest("GET", DefaultEngineioPath+"?EIO=4&transport=polling", nil)
+	w := failingWriter{httptest.NewRecorder()}
+	if _, err := e.handshake(w, req, "sid", -1, 4, false, newValues(nil)); err == nil {
+		t.Fatalf("handshake: expect write error")
+	}
+	if _, found := e.sessions.Get("sid"); found {
+		t.Fatalf("handshake: expect no session")
+	}
+
+	// the heartbeat of the discarded transport must not expire
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestShutdownHandshake(t *testing.T) {
+	e := NewEngineIO(nil)
+	req := httptest.NewRequest("GET", DefaultEngineioPath+"?EIO=4&transport=polling", nil)
+	s, err := e.handshake(httptest.NewRecorder(), req, "sid", -1, 4, false, newValues(nil))
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	// the shutdown begins while the handshake is in flight
+	e.Shutdown(context.Background())
+	if e.register(s) {
+		t.Fatalf("register: expect session to be rejected")
+	}
+	if _, found := e.sessions.Get("sid"); found {
+		t.Fatalf("register: expect session to be removed")
+	}
+	if reason := s.CloseReason(); reason == nil || reason.Code != ServerShutdown {
+		t.Fatalf("register: expect reason %q, got %v", closeMessages[ServerShutdown], reason)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	conns := make(chan Connection, 3)
+	e := NewEngineIO(nil)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	var (
+		clients []*websocket.Conn
+		members []Connection
+		data    string
+	)
+	for i := 0; i < 3; i++ {
+		ws, err := websocket.Dial(url, "", server.URL)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer ws.Close()
+
+		if err = websocket.Message.Receive(ws, &data); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		clients = append(clients, ws)
+
+		conn := <-conns
+		if i > 0 {
+			e.Join(conn, "room")
+		}
+		members = append(members, conn)
+	}
+
+	if err := e.To("room").Except(members[1]).Write([]byte("room")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := e.Broadcast([]byte("all")); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+
+	expected := [][]string{{"4all"}, {"4all"}, {"4room", "4all"}}
+	for i, ws := range clients {
+		for _, msg := range expected[i] {
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				t.Fatalf("receive: %v", err)
+			}
+			if data != msg {
+				t.Fatalf("client %d: expect %q, got %q", i, msg, data)
+			}
+		}
+	}
+
+	e.Leave(members[2], "room")
+	if err := e.To("room").Except(members[1]).Write([]byte("room")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if sids := e.adapter.(*memoryAdapter).members([]string{"room"}); len(sids) != 1 {
+		t.Fatalf("members: expect 1, got %d", len(sids))
+	}
+}
+
+func TestBroadcastError(t *testing.T) {
+	err := BroadcastError{"b": ErrQueueFull, "a": ErrNotConnected, "c": ErrQueueFull}
+	for i := 0; i < 10; i++ {
+		if msg := err.Error(); msg != "broadcast: 3 write(s) failed, session a: not connected" {
+			t.Fatalf("error: expect stable message, got %q", msg)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	config := *DefaultConfig
+	config.Transports = []string{TransportWebsocket}
+	e := NewEngineIO(&config)
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || body.Code != UnknownTransport {
+		t.Fatalf("polling: expect code %d, got %d (%v)", UnknownTransport, body.Code, err)
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !strings.HasPrefix(data, `0{"sid":"`) {
+		t.Fatalf("open: expect open packet, got %q", data)
+	}
+
+	// polling only deployments don't advertise upgrades
+	config.Transports = []string{TransportPolling}
+	open, err := e.openPacket("sid", 4, true)
+	if err != nil || !strings.Contains(string(open), `"upgrades":[]`) {
+		t.Fatalf("open: expect no upgrades, got %s (%v)", open, err)
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	config := *DefaultConfig
+	config.AllowOrigin = func(origin string, req *http.Request) bool {
+		return origin == "https://example.com"
+	}
+	e := NewEngineIO(&config)
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	if ws, err := websocket.Dial(url, "", "https://evil.com"); err == nil {
+		ws.Close()
+		t.Fatalf("dial: expect origin to be rejected")
+	}
+
+	ws, err := websocket.Dial(url, "", "https://example.com")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil || !strings.HasPrefix(data, `0{"sid":"`) {
+		t.Fatalf("open: expect open packet, got %q (%v)", data, err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+DefaultEngineioPath+"?EIO=3&transport=polling&j=0", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("jsonp: %v", err)
+	}
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusForbidden || body.Code != Forbidden {
+		t.Fatalf("jsonp: expect %d %d, got %d %d (%v)", http.StatusForbidden, Forbidden, res.StatusCode, body.Code, err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	config := *DefaultConfig
+	config.Cookie = &Cookie{HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	e := NewEngineIO(&config)
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	cookies := res.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("handshake: expect one cookie, got %v", cookies)
+	}
+	c := cookies[0]
+	if c.Name != "io" || c.Path != "/" || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("handshake: expect io cookie, got %v", c)
+	}
+	if !strings.Contains(string(body), `"sid":"`+c.Value+`"`) {
+		t.Fatalf("handshake: expect cookie to hold sid, got %q %q", c.Value, body)
+	}
+}
+
+func TestHandshakeFunc(t *testing.T) {
+	config := *DefaultConfig
+	config.InitialPacket = []byte("welcome")
+	e := NewEngineIO(&config)
+	e.HandshakeFunc(func(req *http.Request, header http.Header) {
+		header.Set("X-Request-Id", "42")
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if id := res.Header.Get("X-Request-Id"); id != "42" {
+		t.Fatalf("polling: expect request id header, got %q", id)
+	}
+	if !strings.HasPrefix(string(body), `0{"sid":"`) || !strings.HasSuffix(string(body), "\x1e4welcome") {
+		t.Fatalf("polling: expect open and initial packet, got %q", body)
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var open, initial string
+	if err = websocket.Message.Receive(ws, &open); err != nil || !strings.HasPrefix(open, `0{"sid":"`) {
+		t.Fatalf("websocket: expect open packet, got %q (%v)", open, err)
+	}
+	if err = websocket.Message.Receive(ws, &initial); err != nil || initial != "4welcome" {
+		t.Fatalf("websocket: expect initial packet, got %q (%v)", initial, err)
+	}
+}
+
+func TestTransportMismatch(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	closed := make(chan struct{})
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		close(closed)
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	conn := <-conns
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling&sid=" + conn.ID())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusBadRequest || body.Code != BadRequest {
+		t.Fatalf("poll: expect %d %d, got %d %d (%v)", http.StatusBadRequest, BadRequest, res.StatusCode, body.Code, err)
+	}
+
+	// the websocket session is left intact
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("close: expect close callback")
+	}
+}
+
+// wrappedWriter hides the optional interfaces of the underlying
+// http.ResponseWriter, as middleware commonly does.
+type wrappedWriter struct {
+	w http.ResponseWriter
+}
+
+func (w wrappedWriter) Header() http.Header         { return w.w.Header() }
+func (w wrappedWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+func (w wrappedWriter) WriteHeader(code int)        { w.w.WriteHeader(code) }
+
+func TestPollingDuration(t *testing.T) {
+	config := *DefaultConfig
+	config.PollingDuration = 50
+	e := NewEngineIO(&config)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e.ServeHTTP(wrappedWriter{w}, req)
+	}))
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+
+	res, err = http.Get(url + "&sid=" + conn.ID())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "6" {
+		t.Fatalf("poll: expect noop packet, got %q", data)
+	}
+
+	// a cancelled long poll closes the session
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", url+"&sid="+conn.ID(), nil)
+	rec := httptest.NewRecorder()
+	cancel()
+	e.ServeHTTP(rec, req)
+	if _, found := e.sessions.Get(conn.ID()); found {
+		t.Fatalf("cancel: expect session to be removed")
+	}
+}
+
+func TestClosePoll(t *testing.T) {
+	config := *DefaultConfig
+	config.PollingDuration = 5000
+	e := NewEngineIO(&config)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+
+	// the pending poll is answered before the polling duration passes
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+	res, err = http.Get(url + "&sid=" + conn.ID())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "1" {
+		t.Fatalf("poll: expect close packet, got %q", data)
+	}
+}
+
+func TestWebsocketHijack(t *testing.T) {
+	e := NewEngineIO(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e.ServeHTTP(wrappedWriter{w}, req)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+DefaultEngineioPath+"?EIO=4&transport=websocket", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusBadRequest || body.Code != BadRequest {
+		t.Fatalf("websocket: expect %d %d, got %d %d (%v)", http.StatusBadRequest, BadRequest, res.StatusCode, body.Code, err)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	config := *DefaultConfig
+	config.PingInterval = 50
+	config.PingTimeout = 50
+	e := NewEngineIO(&config)
+	reasons := make(chan *CloseReason, 2)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		reasons <- reason
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	// neither client answers the pings
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case reason := <-reasons:
+			if reason.Code != PingTimeout {
+				t.Fatalf("close: expect reason %q, got %q", closeMessages[PingTimeout], reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("close: expect sessions to time out")
+		}
+	}
+}
+
+func TestPollingPong(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=3&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	url += "&sid=" + (<-conns).ID()
+
+	// protocol v3 clients ping, the pong is sent with the long poll
+	res, err = http.Post(url, "text/plain;charset=UTF-8", strings.NewReader("1:2"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "ok" {
+		t.Fatalf("post: expect ok, got %q", data)
+	}
+
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	data, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "1:3" {
+		t.Fatalf("poll: expect pong packet, got %q", data)
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	reasons := make(chan *CloseReason, 1)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		reasons <- reason
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+	if reason := conn.CloseReason(); reason != nil {
+		t.Fatalf("open: expect no close reason, got %q", reason)
+	}
+
+	res, err = http.Post(url+"&sid="+conn.ID(), "text/plain", strings.NewReader("1"))
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	res.Body.Close()
+	if reason := <-reasons; reason.Code != ClientClose {
+		t.Fatalf("close: expect reason %q, got %q", closeMessages[ClientClose], reason)
+	}
+	if reason := conn.CloseReason(); reason == nil || reason.Code != ClientClose {
+		t.Fatalf("close: expect connection reason %q, got %v", closeMessages[ClientClose], reason)
+	}
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+DefaultEngineioPath+"?EIO=4&transport=websocket", "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	<-conns
+
+	e.Close()
+	if reason := <-reasons; reason.Code != ForcedClose {
+		t.Fatalf("close: expect reason %q, got %q", closeMessages[ForcedClose], reason)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	upgraded := make(chan Connection, 1)
+	e.UpgradeFunc(func(conn Connection) {
+		upgraded <- conn
+	})
+	users := make(chan interface{}, 1)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		users <- conn.Get("user")
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+DefaultEngineioPath+"?EIO=4&transport=polling&token=secret", nil)
+	req.Header.Set("X-Client", "test")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+
+	conn := <-conns
+	if conn.Transport() != TransportPolling || conn.RemoteAddr() == "" {
+		t.Fatalf("handshake: expect polling connection, got %q from %q", conn.Transport(), conn.RemoteAddr())
+	}
+	conn.Set("user", "john")
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket&sid=" + conn.ID()
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Send(ws, "2probe"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "3probe" {
+		t.Fatalf("probe: expect \"3probe\", got %q (%v)", data, err)
+	}
+
+	// writes during the upgrade are sent over websocket
+	if _, err = conn.Write([]byte("during")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err = websocket.Message.Send(ws, "5"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "4during" {
+		t.Fatalf("upgrade: expect \"4during\", got %q (%v)", data, err)
+	}
+
+	newConn := <-upgraded
+	if newConn != conn || newConn.Transport() != TransportWebsocket {
+		t.Fatalf("upgrade: expect connection %q on websocket, got %q %q", conn.ID(), newConn.ID(), newConn.Transport())
+	}
+	if h := newConn.Request().Header.Get("X-Client"); h != "test" {
+		t.Fatalf("upgrade: expect handshake header \"test\", got %q", h)
+	}
+	if token := newConn.Request().URL.Query().Get("token"); token != "secret" {
+		t.Fatalf("upgrade: expect handshake query \"secret\", got %q", token)
+	}
+	if req := newConn.Request(); req.Body != http.NoBody || req.Context().Err() != nil {
+		t.Fatalf("upgrade: expect handshake request without body and live context")
+	}
+	if c, _ := e.sessions.Get(conn.ID()); c != conn {
+		t.Fatalf("upgrade: expect session to hold the connection")
+	}
+	if u := newConn.Get("user"); u != "john" {
+		t.Fatalf("upgrade: expect session value \"john\", got %v", u)
+	}
+
+	newConn.Close()
+	if u := <-users; u != "john" {
+		t.Fatalf("close: expect session value \"john\", got %v", u)
+	}
+	if u := newConn.Get("user"); u != nil {
+		t.Fatalf("close: expect session value to be released, got %v", u)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	config := *DefaultConfig
+	config.PingInterval = 50
+	e := NewEngineIO(&config)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	packets := make(chan string, 10)
+	e.PacketFunc(func(conn Connection, p parser.Packet, d Direction) {
+		if p.Type != parser.Ping {
+			packets <- fmt.Sprintf("%d%s%s", d, p.Type, p.Data)
+		}
+	})
+	heartbeats := make(chan Connection, 10)
+	e.HeartbeatFunc(func(conn Connection) {
+		heartbeats <- conn
+	})
+	drains := make(chan Connection, 10)
+	e.DrainFunc(func(conn Connection) {
+		drains <- conn
+	})
+	upgradeErrs := make(chan error, 1)
+	e.UpgradeErrorFunc(func(conn Connection, err error) {
+		upgradeErrs <- err
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if p := <-packets; p != fmt.Sprintf("%d%s", Outbound, data) {
+		t.Fatalf("open: expect outbound open packet, got %q", p)
+	}
+	<-drains
+
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "2" {
+		t.Fatalf("ping: expect \"2\", got %q (%v)", data, err)
+	}
+	if err = websocket.Message.Send(ws, "3"); err != nil {
+		t.Fatalf("pong: %v", err)
+	}
+	if p := <-packets; p != fmt.Sprintf("%d3", Inbound) {
+		t.Fatalf("pong: expect inbound pong packet, got %q", p)
+	}
+	<-heartbeats
+	conn := <-conns
+
+	// a failed probe is reported with the previous connection
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn = <-conns
+
+	ws, err = websocket.Dial(url+"&sid="+conn.ID(), "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	if err = websocket.Message.Send(ws, "2nope!"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err = <-upgradeErrs; err == nil {
+		t.Fatalf("probe: expect upgrade error")
+	}
+}
+
+func TestProbeHooks(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	packets := make(chan string, 10)
+	e.PacketFunc(func(conn Connection, p parser.Packet, d Direction) {
+		if p.Type != parser.Open && p.Type != parser.Noop {
+			packets <- fmt.Sprintf("%d%s%s", d, p.Type, p.Data)
+		}
+	})
+	heartbeats := make(chan Connection, 1)
+	e.HeartbeatFunc(func(conn Connection) {
+		heartbeats <- conn
+	})
+	upgraded := make(chan Connection, 1)
+	e.UpgradeFunc(func(conn Connection) {
+		upgraded <- conn
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket&sid=" + conn.ID()
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Send(ws, "2probe"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "3probe" {
+		t.Fatalf("probe: expect \"3probe\", got %q (%v)", data, err)
+	}
+	if err = websocket.Message.Send(ws, "5"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	<-upgraded
+
+	for _, expected := range []string{
+		fmt.Sprintf("%d2probe", Inbound),
+		fmt.Sprintf("%d3probe", Outbound),
+		fmt.Sprintf("%d5", Inbound),
+	} {
+		if p := <-packets; p != expected {
+			t.Fatalf("upgrade: expect packet %q, got %q", expected, p)
+		}
+	}
+	if c := <-heartbeats; c != conn {
+		t.Fatalf("probe: expect heartbeat of %q", conn.ID())
+	}
+}
+
+func TestCloseDuringUpgrade(t *testing.T) {
+	e := NewEngineIO(nil)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	e.UpgradeFunc(func(conn Connection) {
+		t.Errorf("upgrade: expect closed session not to be upgraded")
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + DefaultEngineioPath + "?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket&sid=" + conn.ID()
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Send(ws, "2probe"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "3probe" {
+		t.Fatalf("probe: expect \"3probe\", got %q (%v)", data, err)
+	}
+
+	// the session is closed before the upgrade completes
+	conn.Close()
+	if err = websocket.Message.Send(ws, "5"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if err = websocket.Message.Receive(ws, &data); err != io.EOF {
+		t.Fatalf("upgrade: expect websocket to be closed, got %q (%v)", data, err)
+	}
+}
+
+func TestUpgradeTimeout(t *testing.T) {
+	config := *DefaultConfig
+	config.UpgradeTimeout = 100
+	e := NewEngineIO(&config)
+	conns := make(chan Connection, 1)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	upgradeErrs := make(chan error, 1)
+	e.UpgradeErrorFunc(func(conn Connection, err error) {
+		upgradeErrs <- err
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	conn := <-conns
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + DefaultEngineioPath + "?EIO=4&transport=websocket&sid=" + conn.ID()
+	ws, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var data string
+	if err = websocket.Message.Send(ws, "2probe"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err = websocket.Message.Receive(ws, &data); err != nil || data != "3probe" {
+		t.Fatalf("probe: expect \"3probe\", got %q (%v)", data, err)
+	}
+
+	// concurrent upgrades are rejected
+	if _, err = websocket.Dial(wsURL, "", server.URL); err == nil {
+		t.Fatalf("dial: expect concurrent upgrade to be rejected")
+	}
+
+	// the upgrade is never completed
+	if err = <-upgradeErrs; err == nil {
+		t.Fatalf("upgrade: expect timeout error")
+	}
+	if conn.Transport() != TransportPolling {
+		t.Fatalf("upgrade: expect polling transport, got %q", conn.Transport())
+	}
+
+	if _, err = conn.Write([]byte("after")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	res, err = http.Get(url + "&sid=" + conn.ID())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "6\x1e4after" {
+		t.Fatalf("poll: expect noop and message, got %q", body)
+	}
+}
+
+func TestMaxPayload(t *testing.T) {
+	config := *DefaultConfig
+	config.MaxPayload = 16
+	e := NewEngineIO(&config)
+	conns := make(chan Connection, 3)
+	e.ConnectionFunc(func(conn Connection) {
+		conns <- conn
+	})
+	reasons := make(chan *CloseReason, 1)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		reasons <- reason
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := server.URL + DefaultEngineioPath + "?EIO=4&transport=polling"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(data), `"maxPayload":16`) {
+		t.Fatalf("handshake: expect maxPayload to be advertised, got %q", data)
+	}
+	var open struct {
+		Sid string `json:"sid"`
+	}
+	if err = json.Unmarshal(data[1:], &open); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	res, err = http.Post(url+"&sid="+open.Sid, "text/plain", strings.NewReader("4"+strings.Repeat("x", 16)))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("post: expect status %d, got %d", http.StatusRequestEntityTooLarge, res.StatusCode)
+	}
+	if reason := <-reasons; reason.Code != PayloadTooLarge {
+		t.Fatalf("post: expect reason %q, got %q", closeMessages[PayloadTooLarge], reason)
+	}
+
+	// jsonp payloads are bounded before the form is parsed
+	jsonp := server.URL + DefaultEngineioPath + "?EIO=3&transport=polling&j=0"
+	res, err = http.Get(jsonp)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	res.Body.Close()
+	<-conns
+	res, err = http.PostForm(jsonp+"&sid="+(<-conns).ID(), neturl.Values{"d": {strings.Repeat("x", 1<<20)}})
+	if err != nil {
+		t.Fatalf("jsonp: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("jsonp: expect status %d, got %d", http.StatusRequestEntityTooLarge, res.StatusCode)
+	}
+	if reason := <-reasons; reason.Code != PayloadTooLarge {
+		t.Fatalf("jsonp: expect reason %q, got %q", closeMessages[PayloadTooLarge], reason)
+	}
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+DefaultEngineioPath+"?EIO=4&transport=websocket", "", server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	var frame string
+	if err = websocket.Message.Receive(ws, &frame); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err = websocket.Message.Send(ws, "4"+strings.Repeat("x", 16)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if reason := <-reasons; reason.Code != PayloadTooLarge {
+		t.Fatalf("send: expect reason %q, got %q", closeMessages[PayloadTooLarge], reason)
+	}
+}
+
+func TestMaxPayloadFrame(t *testing.T) {
+	config := *DefaultConfig
+	config.MaxPayload = 16
+	e := NewEngineIO(&config)
+	reasons := make(chan *CloseReason, 1)
+	e.CloseFunc(func(conn Connection, reason *CloseReason) {
+		reasons <- reason
+	})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET %s?EIO=4&transport=websocket HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nOrigin: %s\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", DefaultEngineioPath, conn.RemoteAddr(), server.URL)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: expect status %d, got %v (%v)", http.StatusSwitchingProtocols, res, err)
+	}
+
+	// the frame claims 16MB of payload, only the bytes exceeding the
+	// max payload are sent
+	header := []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}
+	if _, err = conn.Write(append(header, "4"+strings.Repeat("x", 16)...)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason.Code != PayloadTooLarge {
+			t.Fatalf("send: expect reason %q, got %q", closeMessages[PayloadTooLarge], reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("send: expect frame to be rejected before it is read")
+	}
+}
+
@@ -6,9 +6,16 @@
 package engineio
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/massiveart/engineio/internal/inbox"
+	"github.com/massiveart/engineio/parser"
 )
 
 func newSessionId() string {
@@ -18,3 +25,189 @@ func newSessionId() string {
 	hash.Write(buf)
 	return string(fmt.Sprintf("%x", hash.Sum(nil)))
 }
+
+// transport is the connection a session communicates over.
+type transport interface {
+	io.Closer
+
+	name() string
+	write(parser.Packet) (int, error)
+	close(CloseCode, error) error
+	upgrade(parser.Packet) error
+	abortUpgrade()
+	shutdown(context.Context) error
+	handle(http.ResponseWriter, *http.Request) error
+
+	messageFunc(func(parser.Packet) error)
+	closeFunc(func(*CloseReason))
+	packetFunc(func(parser.Packet, Direction))
+	drainFunc(func())
+}
+
+// session is the Connection of a client. It outlives the upgrade of its
+// transport; writes are buffered while the transport is upgraded and
+// flushed onto the new transport afterwards.
+type session struct {
+	*values
+	metadata
+	*inbox.Inbox
+	closer
+
+	sid         string
+	queueLength int
+	remove      func(sid string)
+	closeFn     func(Connection, *CloseReason)
+
+	mu        sync.Mutex // protects the fields below
+	conn      transport
+	upgrading bool // indicates if writes are buffered
+	pending   []parser.Packet
+	closed    bool
+}
+
+func (s *session) ID() string {
+	return s.sid
+}
+
+func (s *session) Transport() string {
+	return s.transport().name()
+}
+
+// transport returns the current transport.
+func (s *session) transport() transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+func (s *session) Write(data []byte) (int, error) {
+	return s.write(parser.Packet{Type: parser.Message, Data: data})
+}
+
+func (s *session) WriteBinary(data []byte) (int, error) {
+	return s.write(parser.Packet{Type: parser.Message, Data: data, Binary: true})
+}
+
+func (s *session) write(p parser.Packet) (int, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, ErrNotConnected
+	}
+	if s.upgrading {
+		defer s.mu.Unlock()
+		if len(s.pending) >= s.queueLength {
+			return 0, ErrQueueFull
+		}
+		s.pending = append(s.pending, p)
+		return len(p.Data), nil
+	}
+	conn := s.conn
+	s.mu.Unlock()
+
+	return conn.write(p)
+}
+
+// beginUpgrade buffers the following writes until the upgrade ends. It
+// returns false if an upgrade is in progress already.
+func (s *session) beginUpgrade() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.upgrading {
+		return false
+	}
+	s.upgrading = true
+	return true
+}
+
+// endUpgrade switches to transport t and flushes the buffered writes
+// onto it. If they can't be written, the session gets closed. If the
+// session is closed during the upgrade, t is closed. endUpgrade reports
+// if the session goes on with t.
+func (s *session) endUpgrade(t transport) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.upgrading = false
+		s.mu.Unlock()
+		t.Close()
+		return false
+	}
+	s.conn = t
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		if len(pending) == 0 {
+			s.upgrading = false
+			s.mu.Unlock()
+			return true
+		}
+		s.mu.Unlock()
+
+		for _, p := range pending {
+			if _, err := t.write(p); err != nil {
+				s.close(TransportError, err)
+				return false
+			}
+		}
+	}
+}
+
+// push buffers the inbound message data for ReadMessage.
+func (s *session) push(data []byte) error {
+	if err := s.Inbox.Push(data); err != nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (s *session) handle(w http.ResponseWriter, req *http.Request) error {
+	return s.transport().handle(w, req)
+}
+
+func (s *session) shutdown(ctx context.Context) error {
+	return s.transport().shutdown(ctx)
+}
+
+func (s *session) Close() error {
+	return s.close(ForcedClose, nil)
+}
+
+// close closes the session along with its transport, the close reason of
+// code caused by err is passed to the close callback.
+func (s *session) close(code CloseCode, err error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.pending = nil
+	conn := s.conn
+	s.mu.Unlock()
+
+	reason := s.setReason(code, err)
+	s.remove(s.sid)
+	s.Inbox.Close()
+
+	if s.closeFn != nil {
+		s.closeFn(s, reason)
+	}
+	s.values.release()
+
+	return conn.close(code, err)
+}
+
+// transportClosed closes the session if t is its current transport.
+func (s *session) transportClosed(t transport, reason *CloseReason) {
+	s.mu.Lock()
+	current := s.conn == t
+	s.mu.Unlock()
+
+	if current {
+		s.close(reason.Code, reason.Err)
+	}
+}
@@ -0,0 +1,114 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// SessionStore holds the established connections by their session id.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Get returns the connection of session sid and true, or false if
+	// the session is unknown.
+	Get(sid string) (Connection, bool)
+
+	// Put stores conn as the connection of session sid.
+	Put(sid string, conn Connection)
+
+	// Delete removes session sid.
+	Delete(sid string)
+
+	// Range calls fn for each session until fn returns false. fn may
+	// modify the store.
+	Range(fn func(sid string, conn Connection) bool)
+
+	// Len returns the number of sessions.
+	Len() int
+}
+
+const storeShards = 32
+
+type storeShard struct {
+	mu       sync.RWMutex
+	sessions map[string]Connection
+}
+
+// memoryStore is an in-memory SessionStore, sharded by session id to
+// reduce lock contention.
+type memoryStore struct {
+	shards [storeShards]*storeShard
+}
+
+// NewMemoryStore returns a new in-memory SessionStore.
+func NewMemoryStore() SessionStore {
+	s := &memoryStore{}
+	for i := range s.shards {
+		s.shards[i] = &storeShard{sessions: make(map[string]Connection)}
+	}
+	return s
+}
+
+func (s *memoryStore) shard(sid string) *storeShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(sid))
+	return s.shards[hash.Sum32()%storeShards]
+}
+
+func (s *memoryStore) Get(sid string) (Connection, bool) {
+	shard := s.shard(sid)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	conn, found := shard.sessions[sid]
+	return conn, found
+}
+
+func (s *memoryStore) Put(sid string, conn Connection) {
+	shard := s.shard(sid)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.sessions[sid] = conn
+}
+
+func (s *memoryStore) Delete(sid string) {
+	shard := s.shard(sid)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.sessions, sid)
+}
+
+// Range iterates over a snapshot of each shard, fn is invoked without
+// holding any lock.
+func (s *memoryStore) Range(fn func(sid string, conn Connection) bool) {
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		sessions := make(map[string]Connection, len(shard.sessions))
+		for sid, conn := range shard.sessions {
+			sessions[sid] = conn
+		}
+		shard.mu.RUnlock()
+
+		for sid, conn := range sessions {
+			if !fn(sid, conn) {
+				return
+			}
+		}
+	}
+}
+
+func (s *memoryStore) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		n += len(shard.sessions)
+		shard.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+// (c) MASSIVE ART WebServices GmbH
+//
+// This source file is subject to the MIT license that is bundled
+// with this source code in the file LICENSE.
+
+package engineio
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sid := strconv.Itoa(i)
+			s.Put(sid, &session{sid: sid})
+			if _, found := s.Get(sid); !found {
+				t.Errorf("store: expect session %q", sid)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := s.Len(); n != 100 {
+		t.Fatalf("store: expect 100 sessions, got %d", n)
+	}
+
+	s.Range(func(sid string, conn Connection) bool {
+		if conn.ID() != sid {
+			t.Fatalf("store: expect session %q, got %q", sid, conn.ID())
+		}
+		s.Delete(sid)
+		return true
+	})
+
+	if n := s.Len(); n != 0 {
+		t.Fatalf("store: expect 0 sessions, got %d", n)
+	}
+	if _, found := s.Get("1"); found {
+		t.Fatalf("store: expect session \"1\" to be deleted")
+	}
+}
@@ -7,165 +7,292 @@ package engineio
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"code.google.com/p/go.net/websocket"
+	"github.com/massiveart/engineio/internal/frame"
+	"github.com/massiveart/engineio/parser"
 )
 
 type websocketConn struct {
 	conn     *websocket.Conn
-	prevConn Connection
+	prevConn transport // previous transport, nil if connected directly
+	open     []byte    // open packet data, sent if connected directly
+	initial  []byte    // initial message data, sent after the open packet
 
-	ready           chan bool
+	ready           chan error
 	closeConnection chan bool
+	once            sync.Once // guards close
+	heartbeat       *heartbeat
+
+	protocol       int // engine.io protocol revision
+	maxPayload     int64
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+	upgradeTimeout time.Duration
+	originChecked  bool // indicates if the origin is checked by Config.AllowOrigin
+
+	messageFn func(parser.Packet) error
+	closeFn   func(*CloseReason)
+	packetFn  func(parser.Packet, Direction)
+	drainFn   func()
+}
 
-	sid         string
-	remove      chan<- string
-	pingTimeout time.Duration
-
-	messageFn func(Connection, []byte) error
-	closeFn   func(Connection)
+// handle rejects requests of a session connected with websocket, all
+// packets are read from the websocket connection.
+func (c *websocketConn) handle(w http.ResponseWriter, req *http.Request) error {
+	return errors.New("websocket connected already")
 }
 
-func (c *websocketConn) handle(w http.ResponseWriter, req *http.Request) (err error) {
-	c.ready = make(chan bool)
+// accept accepts the websocket connection. If the connection upgrades a
+// previous connection, the probe is answered and the previous connection
+// gets upgraded. Otherwise the open and initial packets are sent. accept
+// returns when the connection is ready to be read from.
+func (c *websocketConn) accept(w http.ResponseWriter, req *http.Request) error {
+	c.ready = make(chan error)
 	c.closeConnection = make(chan bool)
 
 	connection := func(conn *websocket.Conn) {
 		c.conn = conn
 
-		buf := make([]byte, 6)
-		if _, err = c.conn.Read(buf); err != nil {
-			return
-		}
-		if bytes.Compare(buf[0:6], probeRequest) != 0 {
-			err = errors.New("unknown probe message: " + string(buf))
-			return
+		var err error
+		if c.prevConn != nil {
+			// the probe is bound to the upgrade timeout
+			err = c.conn.SetDeadline(time.Now().Add(c.upgradeTimeout * time.Millisecond))
+			if err == nil {
+				err = c.probe()
+			}
+			if err == nil {
+				err = c.conn.SetReadDeadline(time.Time{})
+			}
+		} else {
+			err = c.send(parser.Packet{Type: parser.Open, Data: c.open})
+			if err == nil && c.initial != nil {
+				err = c.send(parser.Packet{Type: parser.Message, Data: c.initial})
+			}
 		}
-
-		if _, err = c.conn.Write(probeResponse); err != nil {
+		if err != nil {
+			c.ready <- err
 			return
 		}
 
-		// Upgrade previous (polling) connection.
-		if err = c.prevConn.upgrade(packet{Type: noopID}); err != nil {
-			err = errors.New("cannot upgrade connection")
-			return
-		}
+		c.ready <- nil
+		<-c.closeConnection
+	}
 
-		if _, err = c.conn.Read(buf); err != nil {
-			return
+	go func() {
+		accepted := false
+		handler := func(conn *websocket.Conn) {
+			accepted = true
+			connection(conn)
 		}
-		if bytes.Compare(buf[0:1], upgradeRequest) != 0 {
-			err = errors.New("unknown upgrade message: " + string(buf))
-			return
+		server := websocket.Server{Handler: handler, Handshake: c.handshake}
+		// response headers, such as the session cookie, are sent along
+		// with the websocket handshake
+		server.Header = w.Header()
+		server.ServeHTTP(w, req)
+
+		// the connection is never invoked if the websocket handshake
+		// fails.
+		if !accepted {
+			c.ready <- errors.New("websocket handshake failed")
 		}
+	}()
 
-		// Now the connection is upgraded and we can safely close the
-		// queue channel and the connection.
-		c.prevConn.Close()
+	if err := <-c.ready; err != nil {
+		return err
+	}
 
-		now := time.Now()
-		// reset read deadline
-		err = c.conn.SetReadDeadline(now.Add(c.pingTimeout * time.Millisecond))
-		if err != nil {
-			return
-		}
-		// reset write deadline
-		err = c.conn.SetWriteDeadline(now.Add(c.pingTimeout * time.Millisecond))
-		if err != nil {
-			return
-		}
+	c.heartbeat = newHeartbeat(c.pingInterval, c.pingTimeout, c.protocol, c.ping, func() {
+		c.close(PingTimeout, nil)
+	})
+	return nil
+}
 
-		c.ready <- true
-		<-c.closeConnection
+// handshake checks the origin of the websocket request like
+// websocket.Handler does, unless it is checked by Config.AllowOrigin
+// already.
+func (c *websocketConn) handshake(config *websocket.Config, req *http.Request) (err error) {
+	if c.originChecked {
+		return nil
+	}
+
+	config.Origin, err = websocket.Origin(config, req)
+	if err == nil && config.Origin == nil {
+		return errors.New("null origin")
+	}
+	return err
+}
+
+// probe answers the probe of the client and upgrades the previous
+// connection.
+func (c *websocketConn) probe() error {
+	buf := make([]byte, 6)
+	if _, err := c.conn.Read(buf); err != nil {
+		return err
+	}
+	if bytes.Compare(buf[0:6], probeRequest) != 0 {
+		return errors.New("unknown probe message: " + string(buf))
+	}
+	c.packet(parser.Packet{Type: parser.Ping, Data: probe}, Inbound)
+
+	if _, err := c.conn.Write(probeResponse); err != nil {
+		return err
+	}
+	c.packet(parser.Packet{Type: parser.Pong, Data: probe}, Outbound)
+
+	// Upgrade previous (polling) connection.
+	if err := c.prevConn.upgrade(parser.Packet{Type: parser.Noop}); err != nil {
+		return errors.New("cannot upgrade connection")
 	}
 
-	go websocket.Handler(connection).ServeHTTP(w, req)
+	if _, err := c.conn.Read(buf); err != nil {
+		return err
+	}
+	if bytes.Compare(buf[0:1], upgradeRequest) != 0 {
+		return errors.New("unknown upgrade message: " + string(buf))
+	}
+	c.packet(parser.Packet{Type: parser.Upgrade}, Inbound)
+	return nil
+}
 
-	<-c.ready
+// ping sends a ping packet.
+func (c *websocketConn) ping() error {
+	return c.send(parser.Packet{Type: parser.Ping})
+}
 
-	return c.reader()
+func (c *websocketConn) name() string {
+	return TransportWebsocket
 }
 
-func (c *websocketConn) ID() string {
-	return c.sid
+func (c *websocketConn) write(p parser.Packet) (int, error) {
+	if err := c.send(p); err != nil {
+		return 0, err
+	}
+	return len(p.Data), nil
 }
 
-func (c *websocketConn) Write(data []byte) (int, error) {
-	defer func() {
-		// reset write deadline
-		now := time.Now()
-		c.conn.SetWriteDeadline(now.Add(c.pingTimeout * time.Millisecond))
-	}()
+// send sends p as a single frame after resetting the write deadline.
+func (c *websocketConn) send(p parser.Packet) error {
+	// reset write deadline
+	c.conn.SetWriteDeadline(time.Now().Add(c.pingTimeout * time.Millisecond))
+
+	err := frame.Codec.Send(c.conn, frame.Frame{
+		Data:   c.encode(p),
+		Binary: p.Binary,
+	})
+	if err != nil {
+		return err
+	}
 
-	packet := packet{Type: messageID, Data: data}
-	return c.conn.Write(c.encode(packet))
+	if c.packetFn != nil {
+		c.packetFn(p, Outbound)
+	}
+	if c.drainFn != nil {
+		c.drainFn()
+	}
+	return nil
 }
 
 // upgrade is a noop on websocket connections.
-func (c *websocketConn) upgrade(p packet) error {
+func (c *websocketConn) upgrade(p parser.Packet) error {
 	return errors.New("websocket upgrade is a noop")
 }
 
+// abortUpgrade is a noop on websocket connections.
+func (c *websocketConn) abortUpgrade() {}
+
 func (c *websocketConn) Close() error {
-	c.remove <- c.sid
-	c.closeConnection <- true
+	return c.close(ForcedClose, nil)
+}
 
-	if c.closeFn != nil {
-		c.closeFn(c)
+// close closes the connection with the close reason of code caused by
+// cause, which is passed to the close callback.
+func (c *websocketConn) close(code CloseCode, cause error) (err error) {
+	closed := false
+	c.once.Do(func() {
+		closed = true
+		if c.heartbeat != nil {
+			c.heartbeat.stop()
+		}
+		c.closeConnection <- true
+		err = c.conn.Close()
+	})
+
+	if closed && c.closeFn != nil {
+		c.closeFn(&CloseReason{Code: code, Err: cause})
 	}
+	return
+}
+
+// shutdown sends a close packet, once it is written or ctx is done the
+// connection gets closed.
+func (c *websocketConn) shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.send(parser.Packet{Type: parser.Close})
+	}()
 
-	return c.conn.Close()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return c.close(ServerShutdown, nil)
 }
 
-func (c *websocketConn) encode(p packet) []byte {
-	return []byte(fmt.Sprintf("%s%s", p.Type, p.Data))
+func (c *websocketConn) encode(p parser.Packet) []byte {
+	return parser.EncodeFrame(p, c.protocol)
 }
 
 // reader closes if a read or write error happens.
 func (c *websocketConn) reader() (err error) {
-	defer c.Close()
+	code := TransportError
+	defer func() {
+		switch err {
+		case io.EOF:
+			c.close(TransportClose, nil)
+		case frame.ErrTooLarge:
+			c.close(PayloadTooLarge, nil)
+		default:
+			c.close(code, err)
+		}
+	}()
 
-	var data []byte
+	var (
+		f frame.Frame
+		p parser.Packet
+	)
 	for {
-		if err = websocket.Message.Receive(c.conn, &data); err != nil {
+		// frames are bounded by the max payload while they are read
+		if f, err = frame.Receive(c.conn, c.maxPayload); err != nil {
 			return
 		}
-		// reset read deadline
-		now := time.Now()
-		err = c.conn.SetReadDeadline(now.Add(c.pingTimeout * time.Millisecond))
-		if err != nil {
+		c.heartbeat.beat()
+
+		if p, err = parser.DecodeFrame(f.Data, f.Binary, c.protocol); err != nil {
 			return
 		}
-
-		p := packet{
-			Type: string(data[:1]),
-			Data: data[1:],
+		if c.packetFn != nil {
+			c.packetFn(p, Inbound)
 		}
 		switch p.Type {
-		case closeID:
+		case parser.Close:
+			code = ClientClose
 			return
 
-		case pingID:
-			_, err = c.conn.Write(c.encode(packet{Type: pongID}))
-			if err != nil {
+		case parser.Ping:
+			if err = c.send(parser.Packet{Type: parser.Pong}); err != nil {
 				return
 			}
 
-			// reset write deadline
-			now = time.Now()
-			err = c.conn.SetWriteDeadline(now.Add(c.pingTimeout * time.Millisecond))
-			if err != nil {
-				return
-			}
-
-		case messageID:
+		case parser.Message:
 			if c.messageFn != nil {
-				if err = c.messageFn(c, p.Data); err != nil {
+				if err = c.messageFn(p); err != nil {
 					return
 				}
 			}
@@ -173,10 +300,24 @@ func (c *websocketConn) reader() (err error) {
 	}
 }
 
-func (c *websocketConn) messageFunc(fn func(Connection, []byte) error) {
+func (c *websocketConn) messageFunc(fn func(parser.Packet) error) {
 	c.messageFn = fn
 }
 
-func (c *websocketConn) closeFunc(fn func(Connection)) {
+func (c *websocketConn) closeFunc(fn func(*CloseReason)) {
 	c.closeFn = fn
 }
+
+func (c *websocketConn) packetFunc(fn func(parser.Packet, Direction)) {
+	c.packetFn = fn
+}
+
+func (c *websocketConn) packet(p parser.Packet, d Direction) {
+	if c.packetFn != nil {
+		c.packetFn(p, d)
+	}
+}
+
+func (c *websocketConn) drainFunc(fn func()) {
+	c.drainFn = fn
+}